@@ -0,0 +1,10 @@
+package constants
+
+const (
+	// DefaultParallel default concurrent branches of a fan-out without parallel option
+	DefaultParallel = 1
+	// DefaultRunParallel default run-wide concurrent branches, 0 for no limit
+	DefaultRunParallel = 0
+	// EnvPrefix prefix of environment variables imported into the root context
+	EnvPrefix = "CRAWL_"
+)
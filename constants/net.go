@@ -5,6 +5,16 @@ import "time"
 const (
 	// DefaultRetry default retry times
 	DefaultRetry = 3
-	// DefaultRetryInterval default retry interval
-	DefaultRetryInterval = time.Second * 10
+	// DefaultRetryInterval default wait before the first retry
+	DefaultRetryInterval = 10 * time.Second
+	// DefaultRetryMaxInterval default upper bound of the wait between retries
+	DefaultRetryMaxInterval = time.Second * 30
+	// DefaultRetryMultiplier default growth of the wait after every retry
+	DefaultRetryMultiplier = 2.0
+	// DefaultRetryJitter default fraction of the wait randomized
+	DefaultRetryJitter = 0.2
+	// DefaultRenderTimeout default time a render action waits for a page
+	DefaultRenderTimeout = time.Second * 30
+	// DefaultNetworkIdle default quiet time after which a rendered page is done loading
+	DefaultNetworkIdle = time.Millisecond * 500
 )
@@ -0,0 +1,196 @@
+package daemon
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nzai/crawl/jobs"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+// startRequest body of start run request
+type startRequest struct {
+	Path string            `json:"path"`
+	Vars map[string]string `json:"vars"`
+}
+
+// runDetail run with progress of its job nodes
+type runDetail struct {
+	Run
+	Progress []jobs.NodeProgress `json:"progress,omitempty"`
+}
+
+// Handler http control api
+//
+//	GET  /jobs              served job files
+//	GET  /runs              recent runs, newest first
+//	POST /runs              start run, body {"path": "job.toml", "vars": {"key": "value"}}
+//	GET  /runs/{id}         run with per job node progress
+//	POST /runs/{id}/cancel  cancel run
+//	GET  /runs/{id}/log     run log, followed until the run finishes unless follow=false
+//	GET  /metrics           prometheus metrics
+//
+// every request must carry the token of the daemon as Authorization: Bearer <token>,
+// without a token every request is refused
+func (d *Daemon) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/jobs", d.handleJobs)
+	mux.HandleFunc("/runs", d.handleRuns)
+	mux.HandleFunc("/runs/", d.handleRun)
+
+	return d.authorize(mux)
+}
+
+// authorize refuse requests without the bearer token of the daemon
+func (d *Daemon) authorize(next http.Handler) http.Handler {
+	expected := []byte("Bearer " + d.options.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := []byte(r.Header.Get("Authorization"))
+		if d.options.Token == "" || subtle.ConstantTimeCompare(given, expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *Daemon) handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, d.JobFiles())
+}
+
+func (d *Daemon) handleRuns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, d.history.Runs())
+	case http.MethodPost:
+		request := new(startRequest)
+		err := json.NewDecoder(r.Body).Decode(request)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		run, err := d.Start(request.Path, request.Vars)
+		switch {
+		case errors.Is(err, ErrUnknownJob):
+			writeError(w, http.StatusNotFound, err)
+		case errors.Is(err, ErrVarNotAllowed):
+			writeError(w, http.StatusBadRequest, err)
+		case errors.Is(err, ErrRunning):
+			writeError(w, http.StatusConflict, err)
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err)
+		default:
+			writeJSON(w, http.StatusCreated, run)
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// handleRun handle /runs/{id} and its sub resources
+func (d *Daemon) handleRun(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/runs/"), "/"), "/")
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil || len(parts) > 2 {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	run, found := d.history.Run(id)
+	if !found {
+		writeError(w, http.StatusNotFound, errors.New("run not found"))
+		return
+	}
+
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		detail := runDetail{Run: run}
+		if run.state != nil {
+			detail.Progress = run.state.progress.Nodes()
+		}
+		writeJSON(w, http.StatusOK, detail)
+	case action == "cancel" && r.Method == http.MethodPost:
+		err = d.Cancel(id)
+		if err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "canceling"})
+	case action == "log" && r.Method == http.MethodGet:
+		if run.state == nil {
+			writeError(w, http.StatusNotFound, errors.New("log of runs before restart is not kept"))
+			return
+		}
+		streamLog(w, r, run.state.log)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// streamLog write log lines, then follow new ones until the run finishes or the client leaves
+func streamLog(w http.ResponseWriter, r *http.Request, buffer *logBuffer) {
+	follow := r.URL.Query().Get("follow") != "false"
+	flusher, _ := w.(http.Flusher)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	next := 0
+	for {
+		lines, to, changed, closed := buffer.read(next)
+		next = to
+		for _, line := range lines {
+			_, err := w.Write([]byte(line))
+			if err != nil {
+				return
+			}
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if closed || !follow {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		zap.L().Warn("write response failed", zap.Error(err))
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
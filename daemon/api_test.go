@@ -0,0 +1,294 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testToken bearer token of test servers
+const testToken = "secret"
+
+// testServer serve the control api of a daemon with a quick and a slow job file
+func testServer(t *testing.T) (*Daemon, *httptest.Server, map[string]string, func()) {
+	d, paths, remove := newTestDaemon(t, map[string]string{
+		"quick": "api_vars = [\"name\"]\n\n[execute]\ncommand = \"true\"\nargs = [\"${name}\"]\n",
+		"slow":  slowJob,
+	})
+
+	server := httptest.NewServer(d.Handler())
+	return d, server, paths, func() {
+		server.Close()
+		remove()
+	}
+}
+
+// request send request to server, decode json response into value unless nil
+func request(t *testing.T, method, url string, body interface{}, value interface{}) int {
+	var reader bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&reader).Encode(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, err := http.NewRequest(method, url, &reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if value != nil {
+		err = json.NewDecoder(response.Body).Decode(value)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, url, err)
+		}
+	}
+
+	return response.StatusCode
+}
+
+// startRun start run of job file through the api
+func startRun(t *testing.T, server *httptest.Server, path string) Run {
+	var run Run
+	status := request(t, http.MethodPost, server.URL+"/runs", startRequest{Path: path}, &run)
+	if status != http.StatusCreated {
+		t.Fatalf("start %s: got status %d, expect %d", path, status, http.StatusCreated)
+	}
+
+	return run
+}
+
+// waitRun poll run until it is no longer running
+func waitRun(t *testing.T, server *httptest.Server, id uint64) Run {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		var run Run
+		status := request(t, http.MethodGet, fmt.Sprintf("%s/runs/%d", server.URL, id), nil, &run)
+		if status != http.StatusOK {
+			t.Fatalf("get run %d: got status %d, expect %d", id, status, http.StatusOK)
+		}
+
+		if run.Status != StatusRunning {
+			return run
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("run %d still running", id)
+	return Run{}
+}
+
+func TestHandlerJobsAndRuns(t *testing.T) {
+	_, server, paths, close := testServer(t)
+	defer close()
+
+	var files []JobFile
+	status := request(t, http.MethodGet, server.URL+"/jobs", nil, &files)
+	if status != http.StatusOK || len(files) != 2 {
+		t.Fatalf("get jobs: got status %d and %d files, expect %d and 2", status, len(files), http.StatusOK)
+	}
+
+	for _, file := range files {
+		if file.Running || !file.Next.IsZero() {
+			t.Errorf("job file %s: got running %v next %v, expect idle without schedule", file.Path, file.Running, file.Next)
+		}
+	}
+
+	first := startRun(t, server, paths["quick"])
+	if run := waitRun(t, server, first.ID); run.Status != StatusSuccess {
+		t.Fatalf("run %d: got status %s, expect %s (%s)", run.ID, run.Status, StatusSuccess, run.Error)
+	}
+
+	second := startRun(t, server, paths["quick"])
+	waitRun(t, server, second.ID)
+
+	var runs []Run
+	status = request(t, http.MethodGet, server.URL+"/runs", nil, &runs)
+	if status != http.StatusOK || len(runs) != 2 {
+		t.Fatalf("get runs: got status %d and %d runs, expect %d and 2", status, len(runs), http.StatusOK)
+	}
+
+	if runs[0].ID != second.ID || runs[1].ID != first.ID {
+		t.Errorf("get runs: got ids %d, %d, expect newest first %d, %d", runs[0].ID, runs[1].ID, second.ID, first.ID)
+	}
+
+	status = request(t, http.MethodPost, server.URL+"/runs", startRequest{Path: "unknown.toml"}, nil)
+	if status != http.StatusNotFound {
+		t.Errorf("start unknown job file: got status %d, expect %d", status, http.StatusNotFound)
+	}
+}
+
+func TestHandlerUnknownRun(t *testing.T) {
+	_, server, _, close := testServer(t)
+	defer close()
+
+	for _, path := range []string{"/runs/42", "/runs/42/log", "/runs/abc"} {
+		var body map[string]string
+		status := request(t, http.MethodGet, server.URL+path, nil, &body)
+		if status != http.StatusNotFound {
+			t.Errorf("get %s: got status %d, expect %d", path, status, http.StatusNotFound)
+		}
+
+		if body["error"] == "" {
+			t.Errorf("get %s: got no error message", path)
+		}
+	}
+}
+
+func TestHandlerCancel(t *testing.T) {
+	_, server, paths, close := testServer(t)
+	defer close()
+
+	run := startRun(t, server, paths["slow"])
+	if run.Status != StatusRunning {
+		t.Fatalf("run %d: got status %s, expect %s", run.ID, run.Status, StatusRunning)
+	}
+
+	// the job file can not run twice at once
+	status := request(t, http.MethodPost, server.URL+"/runs", startRequest{Path: paths["slow"]}, nil)
+	if status != http.StatusConflict {
+		t.Errorf("start running job file: got status %d, expect %d", status, http.StatusConflict)
+	}
+
+	cancelURL := fmt.Sprintf("%s/runs/%d/cancel", server.URL, run.ID)
+	var body map[string]string
+	status = request(t, http.MethodPost, cancelURL, nil, &body)
+	if status != http.StatusAccepted || body["status"] != "canceling" {
+		t.Fatalf("cancel running run: got status %d %v, expect %d canceling", status, body, http.StatusAccepted)
+	}
+
+	if run = waitRun(t, server, run.ID); run.Status != StatusCanceled {
+		t.Errorf("run %d: got status %s, expect %s", run.ID, run.Status, StatusCanceled)
+	}
+
+	status = request(t, http.MethodPost, cancelURL, nil, nil)
+	if status != http.StatusConflict {
+		t.Errorf("cancel finished run: got status %d, expect %d", status, http.StatusConflict)
+	}
+}
+
+func TestHandlerLogCapped(t *testing.T) {
+	d, server, paths, close := testServer(t)
+	defer close()
+
+	run := startRun(t, server, paths["quick"])
+	waitRun(t, server, run.ID)
+
+	current, _ := d.history.Run(run.ID)
+	total := maxLogLines + 500
+	for index := 0; index < total; index++ {
+		fmt.Fprintf(current.state.log, "line %d\n", index)
+	}
+
+	for _, query := range []string{"?follow=false", ""} {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/runs/%d/log%s", server.URL, run.ID, query), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+		if len(lines) != maxLogLines {
+			t.Fatalf("get log%s: got %d lines, expect %d", query, len(lines), maxLogLines)
+		}
+
+		// the oldest lines are dropped
+		first, last := fmt.Sprintf("line %d", total-maxLogLines), fmt.Sprintf("line %d", total-1)
+		if lines[0] != first || lines[len(lines)-1] != last {
+			t.Errorf("get log%s: got lines %q to %q, expect %q to %q", query, lines[0], lines[len(lines)-1], first, last)
+		}
+	}
+}
+
+func TestHandlerUnauthorized(t *testing.T) {
+	d, server, _, close := testServer(t)
+	defer close()
+
+	for _, path := range []string{"/jobs", "/runs", "/runs/1", "/runs/1/log", "/metrics"} {
+		for _, authorization := range []string{"", "Bearer wrong", testToken} {
+			req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+
+			response, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+
+			if response.StatusCode != http.StatusUnauthorized {
+				t.Errorf("get %s with authorization %q: got status %d, expect %d", path, authorization, response.StatusCode, http.StatusUnauthorized)
+			}
+		}
+	}
+
+	// a daemon without token refuses every request
+	d.options.Token = ""
+	open := httptest.NewServer(d.Handler())
+	defer open.Close()
+
+	status := request(t, http.MethodGet, open.URL+"/jobs", nil, nil)
+	if status != http.StatusUnauthorized {
+		t.Errorf("get jobs without daemon token: got status %d, expect %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestHandlerVars(t *testing.T) {
+	_, server, paths, close := testServer(t)
+	defer close()
+
+	tests := []struct {
+		path   string
+		vars   map[string]string
+		expect int
+	}{
+		// only variables listed in api_vars of the job file
+		{paths["quick"], map[string]string{"name": "value"}, http.StatusCreated},
+		{paths["quick"], map[string]string{"name": "value", "command": "rm"}, http.StatusBadRequest},
+		{paths["slow"], map[string]string{"name": "value"}, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		var run Run
+		status := request(t, http.MethodPost, server.URL+"/runs", startRequest{Path: test.path, Vars: test.vars}, &run)
+		if status != test.expect {
+			t.Errorf("start %s with %v: got status %d, expect %d", filepath.Base(test.path), test.vars, status, test.expect)
+		}
+
+		if status == http.StatusCreated {
+			waitRun(t, server, run.ID)
+		}
+	}
+}
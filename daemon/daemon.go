@@ -0,0 +1,412 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nzai/crawl/jobs"
+	"github.com/nzai/crawl/tracing"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrUnknownJob job file is not served
+	ErrUnknownJob = errors.New("job file is not served")
+	// ErrRunning previous run of the job file is still running
+	ErrRunning = errors.New("previous run is still running")
+	// ErrNotRunning run already finished
+	ErrNotRunning = errors.New("run is not running")
+	// ErrVarNotAllowed variable is not listed in api_vars of the job file
+	ErrVarNotAllowed = errors.New("variable is not listed in api_vars of the job file")
+)
+
+// Options daemon options
+type Options struct {
+	// Paths job files to serve, those without a top-level schedule only run when started through the api
+	Paths []string
+	// ReloadInterval interval of checking job files for changes
+	ReloadInterval time.Duration
+	// NewContext create root context of a run
+	NewContext func() (*jobs.Context, error)
+	// Tracer trace every run as a trace named after its job file, nil disables tracing
+	Tracer *tracing.Tracer
+	// Token bearer token every api request must carry, the api refuses every request without one
+	Token string
+}
+
+// Daemon run job files on their schedules or when triggered through the api
+//
+// a run which is due while the previous run of the same job file is still running
+// is skipped, job files are reloaded when their modification time changes and the
+// previous version is kept when the new one is invalid
+type Daemon struct {
+	options Options
+	history *History
+	entries []*entry
+	// runs parent of every run context, canceled when the daemon stops
+	runs context.Context
+	stop context.CancelFunc
+	// tick interval of checking schedules
+	tick time.Duration
+	wg   sync.WaitGroup
+}
+
+// entry served job file
+type entry struct {
+	path     string
+	modTime  time.Time
+	jobs     []*jobs.Job
+	schedule jobs.Schedule
+	// vars variables the api may set on a run
+	vars    map[string]bool
+	next    time.Time
+	running bool
+	mutex   sync.Mutex
+}
+
+// runState live state of a run
+type runState struct {
+	cancel   context.CancelFunc
+	progress *jobs.Progress
+	log      *logBuffer
+}
+
+// JobFile served job file
+type JobFile struct {
+	Path string `json:"path"`
+	// Next next scheduled run, zero for job files without schedule
+	Next    time.Time `json:"next"`
+	Running bool      `json:"running"`
+}
+
+// New create daemon, every job file must be valid
+func New(options Options, history *History) (*Daemon, error) {
+	d := &Daemon{options: options, history: history, tick: time.Second}
+	d.runs, d.stop = context.WithCancel(context.Background())
+	for _, path := range options.Paths {
+		e := &entry{path: path}
+		err := e.load()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		d.entries = append(d.entries, e)
+
+		if e.schedule == nil {
+			zap.L().Info("job file served without schedule, runs only when started through the api", zap.String("path", path))
+			continue
+		}
+
+		zap.L().Info("job file scheduled", zap.String("path", path), zap.Time("next", e.next))
+	}
+
+	return d, nil
+}
+
+// load read job file and its schedule, then schedule the next run
+func (e *entry) load() error {
+	info, err := os.Stat(e.path)
+	if err != nil {
+		return err
+	}
+
+	_jobs, err := jobs.ReadFile(e.path)
+	if err != nil {
+		return err
+	}
+
+	schedule, err := jobs.ReadSchedule(e.path)
+	if err != nil {
+		return err
+	}
+
+	names, err := jobs.ReadAPIVars(e.path)
+	if err != nil {
+		return err
+	}
+
+	vars := make(map[string]bool, len(names))
+	for _, name := range names {
+		vars[name] = true
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.modTime = info.ModTime()
+	e.jobs = _jobs
+	e.schedule = schedule
+	e.vars = vars
+	e.next = time.Time{}
+	if schedule != nil {
+		e.next = schedule.Next(time.Now())
+	}
+
+	return nil
+}
+
+// changed check whether job file was modified since loaded
+func (e *entry) changed() bool {
+	info, err := os.Stat(e.path)
+	if err != nil {
+		zap.L().Warn("stat job file failed", zap.Error(err), zap.String("path", e.path))
+		return false
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return !info.ModTime().Equal(e.modTime)
+}
+
+// due check whether run is due at now and schedule the next one
+func (e *entry) due(now time.Time) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.schedule == nil || now.Before(e.next) {
+		return false
+	}
+
+	e.next = e.schedule.Next(now)
+	return true
+}
+
+// Run run job files on time until ctx is done, then cancel running runs and wait for them
+func (d *Daemon) Run(ctx context.Context) error {
+	reloadInterval := d.options.ReloadInterval
+	if reloadInterval <= 0 {
+		reloadInterval = 5 * time.Second
+	}
+
+	ticker := time.NewTicker(d.tick)
+	defer ticker.Stop()
+
+	reload := time.NewTicker(reloadInterval)
+	defer reload.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			zap.L().Info("daemon stopping, canceling running jobs")
+			d.stop()
+			d.wg.Wait()
+			return nil
+		case now := <-ticker.C:
+			for _, e := range d.entries {
+				if !e.due(now) {
+					continue
+				}
+
+				_, err := d.trigger(e, TriggerSchedule, nil)
+				if err == ErrRunning {
+					run := d.history.start(&Run{Path: e.path, Trigger: TriggerSchedule})
+					d.history.finish(run, StatusSkipped, nil)
+					zap.L().Warn("previous run still running, skip", zap.String("path", e.path))
+				}
+			}
+		case <-reload.C:
+			d.reload()
+		}
+	}
+}
+
+// reload reload changed job files
+func (d *Daemon) reload() {
+	for _, e := range d.entries {
+		if !e.changed() {
+			continue
+		}
+
+		err := e.load()
+		if err != nil {
+			zap.L().Error("reload job file failed, keep previous version",
+				zap.Error(err),
+				zap.String("path", e.path))
+
+			// retry only after the next change
+			if info, err := os.Stat(e.path); err == nil {
+				e.mutex.Lock()
+				e.modTime = info.ModTime()
+				e.mutex.Unlock()
+			}
+			continue
+		}
+
+		zap.L().Info("job file reloaded", zap.String("path", e.path))
+	}
+}
+
+// Start start run of served job file with variables overriding the root context,
+// only variables listed in api_vars of the job file may be set
+func (d *Daemon) Start(path string, vars map[string]string) (Run, error) {
+	for _, e := range d.entries {
+		if samePath(e.path, path) {
+			err := e.allowVars(vars)
+			if err != nil {
+				return Run{}, err
+			}
+
+			run, err := d.trigger(e, TriggerAPI, vars)
+			if err != nil {
+				return Run{}, err
+			}
+
+			current, _ := d.history.Run(run.ID)
+			return current, nil
+		}
+	}
+
+	return Run{}, fmt.Errorf("%w: %s", ErrUnknownJob, path)
+}
+
+// allowVars check that every variable is listed in api_vars of the job file
+func (e *entry) allowVars(vars map[string]string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for key := range vars {
+		if !e.vars[key] {
+			return fmt.Errorf("%w: %s", ErrVarNotAllowed, key)
+		}
+	}
+
+	return nil
+}
+
+// samePath check whether two paths name the same file
+func samePath(a, b string) bool {
+	if a == b {
+		return true
+	}
+
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false
+	}
+
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false
+	}
+
+	return absA == absB
+}
+
+// Cancel cancel running run
+func (d *Daemon) Cancel(id uint64) error {
+	run, found := d.history.Run(id)
+	if !found {
+		return fmt.Errorf("run %d not found", id)
+	}
+
+	if run.Status != StatusRunning || run.state == nil {
+		return ErrNotRunning
+	}
+
+	run.state.cancel()
+	return nil
+}
+
+// JobFiles get served job files
+func (d *Daemon) JobFiles() []JobFile {
+	files := make([]JobFile, len(d.entries))
+	for index, e := range d.entries {
+		e.mutex.Lock()
+		files[index] = JobFile{Path: e.path, Next: e.next, Running: e.running}
+		e.mutex.Unlock()
+	}
+
+	return files
+}
+
+// trigger start run of job file unless it is still running
+func (d *Daemon) trigger(e *entry, trigger string, vars map[string]string) (*Run, error) {
+	e.mutex.Lock()
+	if e.running {
+		e.mutex.Unlock()
+		return nil, ErrRunning
+	}
+
+	e.running = true
+	_jobs := e.jobs
+	e.mutex.Unlock()
+
+	ctx, cancel := context.WithCancel(d.runs)
+	run := d.history.start(&Run{
+		Path:    e.path,
+		Trigger: trigger,
+		Vars:    vars,
+		Status:  StatusRunning,
+		state: &runState{
+			cancel:   cancel,
+			progress: jobs.NewProgress(_jobs),
+			log:      newLogBuffer(),
+		},
+	})
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer func() {
+			e.mutex.Lock()
+			e.running = false
+			e.mutex.Unlock()
+		}()
+		defer cancel()
+
+		d.run(ctx, run, _jobs)
+	}()
+
+	return run, nil
+}
+
+// run run jobs of job file and record the result
+func (d *Daemon) run(done context.Context, run *Run, _jobs []*jobs.Job) {
+	state := run.state
+	defer state.log.close()
+
+	logger := newRunLogger(state.log, run.ID)
+	logger.Info("run start", zap.String("path", run.Path), zap.String("trigger", run.Trigger))
+
+	ctx, err := d.options.NewContext()
+	if err == nil {
+		for key, value := range run.Vars {
+			ctx.Set(key, value)
+		}
+		ctx.SetCancel(done)
+		ctx.SetLogger(logger)
+		ctx.SetProgress(state.progress)
+
+		endTrace := ctx.StartTrace(d.options.Tracer, run.Path)
+		for _, job := range _jobs {
+			err = job.Execute(ctx)
+			if err != nil {
+				break
+			}
+		}
+		endTrace(err)
+	}
+
+	switch {
+	case err == nil:
+		d.history.finish(run, StatusSuccess, nil)
+		logger.Info("run success", zap.String("path", run.Path), zap.Duration("in", time.Since(run.Start)))
+	case done.Err() != nil:
+		d.history.finish(run, StatusCanceled, err)
+		logger.Warn("run canceled", zap.String("path", run.Path), zap.Duration("in", time.Since(run.Start)))
+	default:
+		d.history.finish(run, StatusFailed, err)
+		logger.Error("run failed", zap.Error(err), zap.String("path", run.Path), zap.Duration("in", time.Since(run.Start)))
+	}
+}
+
+// History get run history
+func (d *Daemon) History() *History {
+	return d.history
+}
@@ -0,0 +1,203 @@
+package daemon
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nzai/crawl/jobs"
+)
+
+// slowJob job file running until canceled
+const slowJob = "[execute]\ncommand = \"sleep\"\nargs = [\"30\"]\n"
+
+// every schedule firing at a fixed interval below the one second of cron
+type every time.Duration
+
+// Next get next run time after t
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// newTestDaemon create daemon serving job files written to a temp dir, returns their
+// paths by name and a function waiting for runs and removing the files
+func newTestDaemon(t *testing.T, files map[string]string) (*Daemon, map[string]string, func()) {
+	dir, err := ioutil.TempDir("", "crawl-daemon-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := make(map[string]string)
+	options := Options{
+		ReloadInterval: time.Hour,
+		NewContext:     func() (*jobs.Context, error) { return jobs.NewContext(), nil },
+		Token:          testToken,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name+".toml")
+		err = ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+
+		paths[name] = path
+		options.Paths = append(options.Paths, path)
+	}
+
+	history, err := NewHistory(100, "")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	d, err := New(options, history)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	d.tick = 5 * time.Millisecond
+
+	return d, paths, func() {
+		d.stop()
+		d.wg.Wait()
+		os.RemoveAll(dir)
+	}
+}
+
+// schedule run job file of daemon every interval from now on
+func (d *Daemon) schedule(interval time.Duration) {
+	for _, e := range d.entries {
+		e.mutex.Lock()
+		e.schedule = every(interval)
+		e.next = time.Now()
+		e.mutex.Unlock()
+	}
+}
+
+// eventually wait until condition holds
+func eventually(t *testing.T, message string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// startDaemon run daemon in background, returns function stopping it and
+// failing unless it returns within a second
+func startDaemon(t *testing.T, d *Daemon) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- d.Run(ctx)
+	}()
+
+	return func() {
+		cancel()
+		select {
+		case err := <-stopped:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("daemon still waiting for runs after stop")
+		}
+	}
+}
+
+// countRuns count recent runs by status
+func countRuns(d *Daemon) map[Status]int {
+	counts := make(map[Status]int)
+	for _, run := range d.history.Runs() {
+		counts[run.Status]++
+	}
+
+	return counts
+}
+
+func TestDaemonSchedule(t *testing.T) {
+	d, _, remove := newTestDaemon(t, map[string]string{"quick": "[execute]\ncommand = \"true\"\nargs = []\n"})
+	defer remove()
+
+	d.schedule(20 * time.Millisecond)
+	stop := startDaemon(t, d)
+	eventually(t, "scheduled runs did not fire", func() bool {
+		return countRuns(d)[StatusSuccess] >= 3
+	})
+	stop()
+
+	for _, run := range d.history.Runs() {
+		if run.Trigger != TriggerSchedule {
+			t.Errorf("run %d: got trigger %s, expect %s", run.ID, run.Trigger, TriggerSchedule)
+		}
+	}
+}
+
+func TestDaemonSkipOverlap(t *testing.T) {
+	d, _, remove := newTestDaemon(t, map[string]string{"slow": slowJob})
+	defer remove()
+
+	d.schedule(20 * time.Millisecond)
+	stop := startDaemon(t, d)
+	eventually(t, "due run was not skipped while the previous one is running", func() bool {
+		return countRuns(d)[StatusSkipped] >= 2
+	})
+
+	// stopping cancels the run in flight instead of waiting for it
+	stop()
+
+	counts := countRuns(d)
+	if counts[StatusCanceled] != 1 || counts[StatusRunning] != 0 {
+		t.Errorf("got runs %v, expect the one running canceled", counts)
+	}
+}
+
+func TestDaemonReload(t *testing.T) {
+	d, paths, remove := newTestDaemon(t, map[string]string{"quick": "[execute]\ncommand = \"true\"\nargs = []\n"})
+	defer remove()
+
+	d.options.ReloadInterval = 10 * time.Millisecond
+	stop := startDaemon(t, d)
+	defer stop()
+
+	// write job file with a modification time the previous version can not have
+	write := func(content string, age time.Duration) {
+		err := ioutil.WriteFile(paths["quick"], []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		modTime := time.Now().Add(age)
+		err = os.Chtimes(paths["quick"], modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("schedule = \"1h\"\n\n[execute]\ncommand = \"true\"\nargs = []\n", time.Minute)
+	eventually(t, "changed job file was not reloaded", func() bool {
+		return !d.JobFiles()[0].Next.IsZero()
+	})
+	next := d.JobFiles()[0].Next
+
+	// an invalid version keeps the previous one
+	write("[execute\n", 2*time.Minute)
+	eventually(t, "invalid job file was not checked", func() bool {
+		e := d.entries[0]
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+
+		return e.modTime.After(time.Now().Add(time.Minute))
+	})
+
+	file := d.JobFiles()[0]
+	if !file.Next.Equal(next) {
+		t.Errorf("got next run %v, expect %v of the previous version", file.Next, next)
+	}
+}
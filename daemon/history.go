@@ -0,0 +1,171 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Status run status
+type Status string
+
+// run statuses
+const (
+	StatusRunning Status = "running"
+	StatusSuccess Status = "success"
+	StatusFailed  Status = "failed"
+	// StatusCanceled run was canceled through the api
+	StatusCanceled Status = "canceled"
+	// StatusSkipped run was due while the previous run of the same job file was still running
+	StatusSkipped Status = "skipped"
+)
+
+// run triggers
+const (
+	TriggerSchedule = "schedule"
+	TriggerAPI      = "api"
+)
+
+// Run job file run record
+type Run struct {
+	ID       uint64            `json:"id"`
+	Path     string            `json:"path"`
+	Trigger  string            `json:"trigger"`
+	Vars     map[string]string `json:"vars,omitempty"`
+	Status   Status            `json:"status"`
+	Start    time.Time         `json:"start"`
+	Duration time.Duration     `json:"duration"`
+	Error    string            `json:"error,omitempty"`
+
+	// state live state, kept in memory only
+	state *runState
+}
+
+// History recent runs, finished runs are appended to an optional json lines file
+type History struct {
+	size   int
+	file   string
+	runs   []*Run
+	lastID uint64
+	mutex  sync.Mutex
+}
+
+// NewHistory create history keeping size recent runs, loading the tail of file if given
+func NewHistory(size int, file string) (*History, error) {
+	h := &History{size: size, file: file}
+	if file == "" {
+		return h, nil
+	}
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		run := new(Run)
+		err = json.Unmarshal(scanner.Bytes(), run)
+		if err != nil {
+			zap.L().Warn("skip invalid history line", zap.Error(err), zap.String("path", file))
+			continue
+		}
+
+		h.append(run)
+		if run.ID > h.lastID {
+			h.lastID = run.ID
+		}
+	}
+
+	return h, scanner.Err()
+}
+
+// append add run and drop the oldest beyond size
+func (h *History) append(run *Run) {
+	h.runs = append(h.runs, run)
+	if h.size > 0 && len(h.runs) > h.size {
+		h.runs = h.runs[len(h.runs)-h.size:]
+	}
+}
+
+// start record run, set its id and start time
+func (h *History) start(run *Run) *Run {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.lastID++
+	run.ID = h.lastID
+	run.Start = time.Now()
+	h.append(run)
+
+	return run
+}
+
+// finish set run result and save it
+func (h *History) finish(run *Run, status Status, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	run.Status = status
+	run.Duration = time.Since(run.Start)
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	h.save(run)
+}
+
+// save append run to history file
+func (h *History) save(run *Run) {
+	if h.file == "" {
+		return
+	}
+
+	f, err := os.OpenFile(h.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		zap.L().Error("open history file failed", zap.Error(err), zap.String("path", h.file))
+		return
+	}
+	defer f.Close()
+
+	err = json.NewEncoder(f).Encode(run)
+	if err != nil {
+		zap.L().Error("save run history failed", zap.Error(err), zap.String("path", h.file))
+	}
+}
+
+// Run get recent run by id
+func (h *History) Run(id uint64) (Run, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, run := range h.runs {
+		if run.ID == id {
+			return *run, true
+		}
+	}
+
+	return Run{}, false
+}
+
+// Runs get recent runs, newest first
+func (h *History) Runs() []Run {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	runs := make([]Run, len(h.runs))
+	for index, run := range h.runs {
+		runs[len(h.runs)-1-index] = *run
+	}
+
+	return runs
+}
@@ -0,0 +1,76 @@
+package daemon
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// maxLogLines lines kept of every run log
+const maxLogLines = 10000
+
+// logBuffer bounded log of a run which can be followed while it is written
+type logBuffer struct {
+	lines   []string
+	dropped int
+	closed  bool
+	changed chan struct{}
+	mutex   sync.Mutex
+}
+
+func newLogBuffer() *logBuffer {
+	return &logBuffer{changed: make(chan struct{})}
+}
+
+// Write append log entry, zap writes one entry per call
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.lines = append(b.lines, string(p))
+	if len(b.lines) > maxLogLines {
+		b.dropped += len(b.lines) - maxLogLines
+		b.lines = b.lines[len(b.lines)-maxLogLines:]
+	}
+
+	b.notify()
+	return len(p), nil
+}
+
+// close mark log finished and wake followers
+func (b *logBuffer) close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.closed = true
+	b.notify()
+}
+
+func (b *logBuffer) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// read get lines from line number on, the next line number, a channel closed on the next
+// change and whether the log is finished, lines dropped from the buffer are skipped
+func (b *logBuffer) read(from int) ([]string, int, <-chan struct{}, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if from < b.dropped {
+		from = b.dropped
+	}
+
+	lines := append([]string(nil), b.lines[from-b.dropped:]...)
+	return lines, b.dropped + len(b.lines), b.changed, b.closed
+}
+
+// newRunLogger create logger writing to the global logger and to buffer
+func newRunLogger(buffer *logBuffer, id uint64) *zap.Logger {
+	global := zap.L().Core()
+	encoder := zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	core := zapcore.NewTee(global, zapcore.NewCore(encoder, zapcore.AddSync(buffer), zap.LevelEnablerFunc(global.Enabled)))
+
+	return zap.New(core, zap.AddCaller()).With(zap.Uint64("run", id))
+}
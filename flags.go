@@ -0,0 +1,252 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nzai/crawl/constants"
+	"github.com/nzai/crawl/jobs"
+	"github.com/nzai/crawl/tracing"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logOptions logger flags shared by every command
+type logOptions struct {
+	level  string
+	format string
+	file   string
+}
+
+// addLogFlags register logger flags
+func addLogFlags(fs *flag.FlagSet) *logOptions {
+	o := new(logOptions)
+	fs.StringVar(&o.level, "log-level", "debug", "log level: debug, info, warn or error")
+	fs.StringVar(&o.format, "log-format", "console", "log format: console or json")
+	fs.StringVar(&o.file, "log-file", "", "write logs to file instead of stderr")
+
+	return o
+}
+
+// newLogger build logger from flags, logs go to output instead of stderr when it is not nil
+func (o logOptions) newLogger(output io.Writer) (*zap.Logger, error) {
+	var c zap.Config
+	switch o.format {
+	case "console":
+		c = zap.NewDevelopmentConfig()
+	case "json":
+		c = zap.NewProductionConfig()
+		c.Sampling = nil
+	default:
+		return nil, fmt.Errorf("invalid log format [%s], expect console or json", o.format)
+	}
+
+	c.DisableStacktrace = true
+	err := c.Level.UnmarshalText([]byte(o.level))
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level [%s]: %w", o.level, err)
+	}
+
+	if o.file != "" {
+		c.OutputPaths = []string{o.file}
+		return c.Build()
+	}
+
+	if output == nil {
+		return c.Build()
+	}
+
+	encoder := zapcore.NewConsoleEncoder(c.EncoderConfig)
+	if c.Encoding == "json" {
+		encoder = zapcore.NewJSONEncoder(c.EncoderConfig)
+	}
+
+	return c.Build(zap.WrapCore(func(zapcore.Core) zapcore.Core {
+		return zapcore.NewCore(encoder, zapcore.AddSync(output), c.Level)
+	}))
+}
+
+// setup replace global logger, returns function restoring it
+//
+// output replaces stderr as in newLogger
+func (o logOptions) setup(output io.Writer) (func(), error) {
+	logger, err := o.newLogger(output)
+	if err != nil {
+		return nil, err
+	}
+
+	undo := zap.ReplaceGlobals(logger)
+	return func() {
+		logger.Sync()
+		undo()
+	}, nil
+}
+
+// runOptions flags of run, plan and serve commands
+type runOptions struct {
+	log           *logOptions
+	strict        bool
+	parallel      int
+	levelParallel string
+	sets          setFlags
+	varsPath      string
+	envNames      string
+	metrics       string
+	traceFile     string
+	traceOTLP     string
+	proxy         string
+	concurrent    bool
+	dryRun        bool
+	fetch         bool
+	progress      string
+	report        string
+}
+
+// addRunFlags register flags of run, plan or serve command
+func addRunFlags(fs *flag.FlagSet, command string) *runOptions {
+	o := &runOptions{log: addLogFlags(fs), sets: make(setFlags)}
+	fs.BoolVar(&o.strict, "strict", false, "referencing undefined variable is an error")
+	fs.IntVar(&o.parallel, "parallel", constants.DefaultRunParallel, "run-wide concurrent branches, 0 for no limit")
+	fs.StringVar(&o.levelParallel, "level-parallel", "", "concurrent branches per job depth, e.g. 1=2,2=8")
+	fs.Var(o.sets, "var", "set root context value, e.g. -var bucket=photos, repeatable")
+	fs.Var(o.sets, "set", "alias of -var")
+	fs.StringVar(&o.varsPath, "vars", "", "load root context values from a KEY=VALUE .env file or a json file")
+	fs.StringVar(&o.envNames, "env", "", "import environment variables by name, e.g. HOME,USER, besides CRAWL_ prefixed ones")
+	fs.StringVar(&o.metrics, "metrics", "", "serve prometheus metrics on address at /metrics, e.g. :9090")
+	fs.StringVar(&o.traceFile, "trace-file", "", "write trace spans of job runs to json lines file")
+	fs.StringVar(&o.traceOTLP, "trace-otlp", "", "send trace spans to OpenTelemetry collector over OTLP/HTTP, e.g. http://localhost:4318")
+	fs.StringVar(&o.proxy, "proxy", "", "proxy urls used in turn by fetch actions without proxy option in job files without a default pool, comma separated")
+
+	if command == "serve" {
+		return o
+	}
+
+	fs.BoolVar(&o.fetch, "fetch", false, "run fetch and render actions in plan mode")
+	if command == "run" {
+		fs.BoolVar(&o.concurrent, "concurrent", false, "run job files concurrently instead of in sequence")
+		fs.BoolVar(&o.dryRun, "dry-run", false, "print what the job would do without running side effects, same as plan")
+		fs.StringVar(&o.progress, "progress", "auto", "live progress view on stderr: auto when stderr is a terminal, always or never")
+		fs.StringVar(&o.report, "report", "", "write json report of counts, durations and errors by action to file when the run finishes")
+	}
+
+	return o
+}
+
+// setProxies set proxies of the proxy flag
+func (o runOptions) setProxies() error {
+	var urls []string
+	for _, u := range strings.Split(o.proxy, ",") {
+		if strings.TrimSpace(u) != "" {
+			urls = append(urls, u)
+		}
+	}
+
+	return jobs.SetProxies(urls)
+}
+
+// setFlags repeatable key=value flag
+type setFlags map[string]string
+
+// String format flag value
+func (s setFlags) String() string {
+	pairs := make([]string, 0, len(s))
+	for key, value := range s {
+		pairs = append(pairs, key+"="+value)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// Set parse key=value pair
+func (s setFlags) Set(value string) error {
+	pos := strings.Index(value, "=")
+	if pos <= 0 {
+		return fmt.Errorf("invalid value [%s], expect key=value", value)
+	}
+
+	s[value[:pos]] = value[pos+1:]
+	return nil
+}
+
+// parseLevels parse depth=limit pairs separated by comma
+func parseLevels(value string) (map[int]int, error) {
+	levels := make(map[int]int)
+	if value == "" {
+		return levels, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		pos := strings.Index(pair, "=")
+		if pos < 0 {
+			return nil, fmt.Errorf("invalid level parallel [%s], expect depth=limit", pair)
+		}
+
+		depth, err := strconv.Atoi(strings.TrimSpace(pair[:pos]))
+		if err != nil {
+			return nil, err
+		}
+
+		limit, err := strconv.Atoi(strings.TrimSpace(pair[pos+1:]))
+		if err != nil {
+			return nil, err
+		}
+
+		levels[depth] = limit
+	}
+
+	return levels, nil
+}
+
+// serveMetrics serve prometheus metrics on address in background, returns function stopping it
+func serveMetrics(address string) func() {
+	if address == "" {
+		return func() {}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	server := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			zap.L().Error("serve metrics failed", zap.Error(err), zap.String("address", address))
+		}
+	}()
+
+	zap.L().Info("metrics listening", zap.String("address", address))
+	return func() {
+		server.Close()
+	}
+}
+
+// newTracer create tracer from trace flags, nil when tracing is disabled
+func (o runOptions) newTracer() (*tracing.Tracer, error) {
+	var exporters []tracing.Exporter
+	if o.traceFile != "" {
+		exporter, err := tracing.NewFileExporter(o.traceFile)
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, exporter)
+	}
+
+	if o.traceOTLP != "" {
+		exporter, err := tracing.NewOTLPExporter(o.traceOTLP, "crawl")
+		if err != nil {
+			return nil, err
+		}
+		exporters = append(exporters, exporter)
+	}
+
+	if len(exporters) == 0 {
+		return nil, nil
+	}
+
+	return tracing.NewTracer(exporters...), nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"testing"
+)
+
+func TestRunFlagsVars(t *testing.T) {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	o := addRunFlags(fs, "run")
+
+	// -set is an alias of -var, the later of both wins
+	err := fs.Parse([]string{"-var", "bucket=photos", "-set", "env=prod", "-set", "region=eu", "-var", "region=us", "job.toml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := o.newContext("/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := map[string]string{"bucket": "photos", "env": "prod", "region": "us", "root": "/data"}
+	for key, value := range expect {
+		got, err := ctx.String(key)
+		if err != nil || got != value {
+			t.Errorf("%s = %q, %v, expect %q", key, got, err, value)
+		}
+	}
+}
@@ -0,0 +1,566 @@
+package jobs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrBrowserNotFound no chromium executable in PATH
+	ErrBrowserNotFound = errors.New("chromium not found, install chromium or set browser option")
+	// ErrBrowserClosed devtools connection closed
+	ErrBrowserClosed = errors.New("browser closed")
+)
+
+// browserNames chromium executables searched in PATH
+var browserNames = []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "headless-shell", "chrome"}
+
+// browsers started or connected browsers by browser option, shared by render actions
+var (
+	browsers      = make(map[string]*browser)
+	browsersMutex sync.Mutex
+)
+
+// browser chromium controlled over the devtools protocol
+//
+// one websocket connection to the browser carries every page, messages of a
+// page are routed by the session id of the flattened target session
+type browser struct {
+	conn       *websocket.Conn
+	process    *exec.Cmd
+	dataDir    string
+	nextID     int64
+	calls      map[int64]chan cdpMessage
+	pages      map[string]*page
+	closed     chan struct{}
+	mutex      sync.Mutex
+	writeMutex sync.Mutex
+}
+
+// cdpRequest devtools command
+type cdpRequest struct {
+	ID        int64       `json:"id"`
+	SessionID string      `json:"sessionId,omitempty"`
+	Method    string      `json:"method"`
+	Params    interface{} `json:"params,omitempty"`
+}
+
+// cdpMessage devtools command result or event
+type cdpMessage struct {
+	ID        int64           `json:"id"`
+	SessionID string          `json:"sessionId"`
+	Method    string          `json:"method"`
+	Params    json.RawMessage `json:"params"`
+	Result    json.RawMessage `json:"result"`
+	Error     *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// getBrowser get shared browser, start or connect it on first use
+//
+// option is a devtools url of a running browser, e.g. http://127.0.0.1:9222,
+// a chromium executable path, or empty to find chromium in PATH
+func getBrowser(option string) (*browser, error) {
+	browsersMutex.Lock()
+	defer browsersMutex.Unlock()
+
+	b, found := browsers[option]
+	if found {
+		select {
+		case <-b.closed:
+			// browser crashed or connection lost, start another one
+		default:
+			return b, nil
+		}
+	}
+
+	var err error
+	if strings.HasPrefix(option, "http://") || strings.HasPrefix(option, "https://") ||
+		strings.HasPrefix(option, "ws://") || strings.HasPrefix(option, "wss://") {
+		b, err = connectBrowser(option)
+	} else {
+		b, err = startBrowser(option)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	browsers[option] = b
+	return b, nil
+}
+
+// CloseBrowsers close browsers started by render actions
+func CloseBrowsers() {
+	browsersMutex.Lock()
+	defer browsersMutex.Unlock()
+
+	for option, b := range browsers {
+		b.close()
+		delete(browsers, option)
+	}
+}
+
+// connectBrowser connect to running browser, http urls are resolved through /json/version
+func connectBrowser(endpoint string) (*browser, error) {
+	if strings.HasPrefix(endpoint, "http") {
+		// a browser which accepts the connection but never answers must not hang the render
+		client := http.Client{Timeout: 10 * time.Second}
+		response, err := client.Get(strings.TrimSuffix(endpoint, "/") + "/json/version")
+		if err != nil {
+			return nil, err
+		}
+		defer response.Body.Close()
+
+		version := struct {
+			WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+		}{}
+		err = json.NewDecoder(response.Body).Decode(&version)
+		if err != nil {
+			return nil, fmt.Errorf("read devtools version of %s failed: %w", endpoint, err)
+		}
+
+		endpoint = version.WebSocketDebuggerURL
+	}
+
+	return dialBrowser(endpoint, nil, "")
+}
+
+// startBrowser start headless chromium listening on a random devtools port
+func startBrowser(executable string) (*browser, error) {
+	if executable == "" {
+		for _, name := range browserNames {
+			path, err := exec.LookPath(name)
+			if err == nil {
+				executable = path
+				break
+			}
+		}
+
+		if executable == "" {
+			return nil, ErrBrowserNotFound
+		}
+	}
+
+	dataDir, err := ioutil.TempDir("", "crawl-chromium-")
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{
+		"--headless",
+		"--disable-gpu",
+		"--no-first-run",
+		"--no-default-browser-check",
+		"--remote-debugging-port=0",
+		"--user-data-dir=" + dataDir,
+	}
+	// chromium refuses to run as root with its sandbox
+	if os.Geteuid() == 0 {
+		zap.L().Warn("running as root, browser started without its sandbox", zap.String("executable", executable))
+		args = append(args, "--no-sandbox")
+	}
+	args = append(args, "about:blank")
+
+	process := exec.Command(executable, args...)
+	stderr, err := process.StderrPipe()
+	if err != nil {
+		os.RemoveAll(dataDir)
+		return nil, err
+	}
+
+	err = process.Start()
+	if err != nil {
+		os.RemoveAll(dataDir)
+		return nil, err
+	}
+
+	endpoint, err := readDevToolsURL(stderr)
+	if err != nil {
+		process.Process.Kill()
+		process.Wait()
+		os.RemoveAll(dataDir)
+		return nil, fmt.Errorf("start %s failed: %w", executable, err)
+	}
+
+	b, err := dialBrowser(endpoint, process, dataDir)
+	if err != nil {
+		process.Process.Kill()
+		process.Wait()
+		os.RemoveAll(dataDir)
+		return nil, err
+	}
+
+	zap.L().Info("browser started", zap.String("executable", executable), zap.String("devtools", endpoint))
+	return b, nil
+}
+
+// readDevToolsURL wait for the devtools url chromium prints on start, then drain its output
+func readDevToolsURL(stderr io.Reader) (string, error) {
+	const prefix = "DevTools listening on "
+
+	found := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if strings.HasPrefix(line, prefix) {
+				found <- strings.TrimPrefix(line, prefix)
+				io.Copy(ioutil.Discard, stderr)
+				return
+			}
+		}
+		close(found)
+	}()
+
+	select {
+	case endpoint, ok := <-found:
+		if !ok {
+			return "", errors.New("browser exited before listening")
+		}
+		return endpoint, nil
+	case <-time.After(30 * time.Second):
+		return "", errors.New("browser did not listen in 30s")
+	}
+}
+
+// dialBrowser open devtools websocket and start reading messages
+func dialBrowser(endpoint string, process *exec.Cmd, dataDir string) (*browser, error) {
+	dialer := websocket.Dialer{HandshakeTimeout: 10 * time.Second}
+	conn, _, err := dialer.Dial(endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("connect devtools %s failed: %w", endpoint, err)
+	}
+	// pages can be large
+	conn.SetReadLimit(256 << 20)
+
+	b := &browser{
+		conn:    conn,
+		process: process,
+		dataDir: dataDir,
+		calls:   make(map[int64]chan cdpMessage),
+		pages:   make(map[string]*page),
+		closed:  make(chan struct{}),
+	}
+	go b.read()
+
+	return b, nil
+}
+
+// read route command results to callers and events to pages until the connection closes
+func (b *browser) read() {
+	defer close(b.closed)
+
+	for {
+		var message cdpMessage
+		err := b.conn.ReadJSON(&message)
+		if err != nil {
+			return
+		}
+
+		b.mutex.Lock()
+		if message.ID != 0 {
+			if reply, found := b.calls[message.ID]; found {
+				reply <- message
+				delete(b.calls, message.ID)
+			}
+		} else if p, found := b.pages[message.SessionID]; found {
+			p.event(message.Method, message.Params)
+		}
+		b.mutex.Unlock()
+	}
+}
+
+// call send command and wait for its result, an empty session id targets the browser
+func (b *browser) call(done context.Context, sessionID, method string, params, result interface{}) error {
+	id := atomic.AddInt64(&b.nextID, 1)
+	reply := make(chan cdpMessage, 1)
+
+	b.mutex.Lock()
+	b.calls[id] = reply
+	b.mutex.Unlock()
+
+	defer func() {
+		b.mutex.Lock()
+		delete(b.calls, id)
+		b.mutex.Unlock()
+	}()
+
+	b.writeMutex.Lock()
+	err := b.conn.WriteJSON(cdpRequest{ID: id, SessionID: sessionID, Method: method, Params: params})
+	b.writeMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	select {
+	case message := <-reply:
+		if message.Error != nil {
+			return fmt.Errorf("%s failed: %s", method, message.Error.Message)
+		}
+
+		if result == nil {
+			return nil
+		}
+
+		return json.Unmarshal(message.Result, result)
+	case <-b.closed:
+		return ErrBrowserClosed
+	case <-done.Done():
+		return done.Err()
+	}
+}
+
+// close close browser connection, stop the browser if it was started here
+func (b *browser) close() {
+	if b.process != nil {
+		done, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		b.call(done, "", "Browser.close", nil, nil)
+		cancel()
+	}
+
+	b.conn.Close()
+
+	if b.process != nil {
+		exited := make(chan struct{})
+		go func() {
+			b.process.Wait()
+			close(exited)
+		}()
+
+		select {
+		case <-exited:
+		case <-time.After(5 * time.Second):
+			b.process.Process.Kill()
+			<-exited
+		}
+
+		os.RemoveAll(b.dataDir)
+	}
+}
+
+// page state of one tab, updated from devtools events
+type page struct {
+	loaded       bool
+	inflight     map[string]bool
+	lastActivity time.Time
+	// documents http status of the last document response of each frame
+	documents map[string]int
+	mutex     sync.Mutex
+}
+
+// event update page state from event
+func (p *page) event(method string, params json.RawMessage) {
+	event := struct {
+		RequestID string `json:"requestId"`
+		FrameID   string `json:"frameId"`
+		Type      string `json:"type"`
+		Response  struct {
+			Status int `json:"status"`
+		} `json:"response"`
+	}{}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	switch method {
+	case "Page.loadEventFired":
+		p.loaded = true
+		return
+	case "Network.requestWillBeSent":
+		json.Unmarshal(params, &event)
+		p.inflight[event.RequestID] = true
+	case "Network.responseReceived":
+		json.Unmarshal(params, &event)
+		if event.Type == "Document" {
+			p.documents[event.FrameID] = event.Response.Status
+		}
+	case "Network.loadingFinished", "Network.loadingFailed":
+		json.Unmarshal(params, &event)
+		delete(p.inflight, event.RequestID)
+	default:
+		return
+	}
+
+	p.lastActivity = time.Now()
+}
+
+// idle check whether the page loaded and had no network requests for the quiet time
+func (p *page) idle(quiet time.Duration) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.loaded && len(p.inflight) == 0 && time.Since(p.lastActivity) >= quiet
+}
+
+// status get http status of the document of frame, 0 if unknown
+func (p *page) status(frameID string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.documents[frameID]
+}
+
+// renderOptions how a page is loaded
+type renderOptions struct {
+	headers map[string]string
+	// wait css selector that must match before the page is read, empty waits for network idle
+	wait string
+	idle time.Duration
+}
+
+// render load url in a new tab and get the rendered html
+func (b *browser) render(done context.Context, url string, options renderOptions) (string, error) {
+	target := struct {
+		TargetID string `json:"targetId"`
+	}{}
+	err := b.call(done, "", "Target.createTarget", map[string]interface{}{"url": "about:blank"}, &target)
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		closeDone, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		b.call(closeDone, "", "Target.closeTarget", map[string]interface{}{"targetId": target.TargetID}, nil)
+	}()
+
+	session := struct {
+		SessionID string `json:"sessionId"`
+	}{}
+	err = b.call(done, "", "Target.attachToTarget", map[string]interface{}{"targetId": target.TargetID, "flatten": true}, &session)
+	if err != nil {
+		return "", err
+	}
+
+	p := &page{inflight: make(map[string]bool), documents: make(map[string]int), lastActivity: time.Now()}
+	b.mutex.Lock()
+	b.pages[session.SessionID] = p
+	b.mutex.Unlock()
+
+	defer func() {
+		b.mutex.Lock()
+		delete(b.pages, session.SessionID)
+		b.mutex.Unlock()
+	}()
+
+	for _, method := range []string{"Page.enable", "Network.enable"} {
+		err = b.call(done, session.SessionID, method, nil, nil)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if len(options.headers) > 0 {
+		err = b.call(done, session.SessionID, "Network.setExtraHTTPHeaders", map[string]interface{}{"headers": options.headers}, nil)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	navigation := struct {
+		FrameID   string `json:"frameId"`
+		ErrorText string `json:"errorText"`
+	}{}
+	err = b.call(done, session.SessionID, "Page.navigate", map[string]interface{}{"url": url}, &navigation)
+	if err != nil {
+		return "", err
+	}
+
+	if navigation.ErrorText != "" {
+		return "", fmt.Errorf("navigate failed: %s", navigation.ErrorText)
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		ready := p.idle(options.idle)
+		if options.wait != "" {
+			ready, err = b.matches(done, session.SessionID, options.wait)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		if ready {
+			break
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done.Done():
+			if options.wait != "" {
+				return "", fmt.Errorf("wait for selector %s: %w", options.wait, done.Err())
+			}
+			return "", fmt.Errorf("wait for network idle: %w", done.Err())
+		}
+	}
+
+	switch status := p.status(navigation.FrameID); {
+	case status == http.StatusNotFound:
+		return "", ErrNotFound
+	case status >= http.StatusBadRequest:
+		return "", &StatusError{URL: url, StatusCode: status}
+	}
+
+	var html string
+	err = b.evaluate(done, session.SessionID, "document.documentElement.outerHTML", &html)
+	if err != nil {
+		return "", err
+	}
+
+	return html, nil
+}
+
+// matches check whether css selector matches an element of the page
+func (b *browser) matches(done context.Context, sessionID, selector string) (bool, error) {
+	quoted, err := json.Marshal(selector)
+	if err != nil {
+		return false, err
+	}
+
+	var found bool
+	err = b.evaluate(done, sessionID, fmt.Sprintf("document.querySelector(%s) !== null", quoted), &found)
+	return found, err
+}
+
+// evaluate evaluate javascript expression in page and decode its value
+func (b *browser) evaluate(done context.Context, sessionID, expression string, value interface{}) error {
+	result := struct {
+		Result struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"result"`
+		ExceptionDetails *struct {
+			Text string `json:"text"`
+		} `json:"exceptionDetails"`
+	}{}
+	err := b.call(done, sessionID, "Runtime.evaluate", map[string]interface{}{
+		"expression":    expression,
+		"returnByValue": true,
+	}, &result)
+	if err != nil {
+		return err
+	}
+
+	if result.ExceptionDetails != nil {
+		return fmt.Errorf("evaluate %s failed: %s", expression, result.ExceptionDetails.Text)
+	}
+
+	return json.Unmarshal(result.Result.Value, value)
+}
@@ -0,0 +1,204 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// devTools devtools stand-in which loads pages with plain http requests, enough
+// of the protocol for render: pages load once their document response arrived
+type devTools struct {
+	server      *httptest.Server
+	connections int
+	mutex       sync.Mutex
+}
+
+// newDevTools start devtools stand-in
+func newDevTools() *devTools {
+	d := new(devTools)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/json/version", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"webSocketDebuggerUrl": "ws://" + r.Host + "/devtools/browser"})
+	})
+	mux.HandleFunc("/devtools/browser", d.serve)
+	d.server = httptest.NewServer(mux)
+
+	return d
+}
+
+// serve answer devtools commands of one connection
+func (d *devTools) serve(w http.ResponseWriter, r *http.Request) {
+	d.mutex.Lock()
+	d.connections++
+	d.mutex.Unlock()
+
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sessions := 0
+	headers := make(map[string]map[string]string)
+	documents := make(map[string]string)
+	for {
+		var request struct {
+			ID        int64                  `json:"id"`
+			SessionID string                 `json:"sessionId"`
+			Method    string                 `json:"method"`
+			Params    map[string]interface{} `json:"params"`
+		}
+		err = conn.ReadJSON(&request)
+		if err != nil {
+			return
+		}
+
+		result := map[string]interface{}{}
+		var events []cdpMessage
+		switch request.Method {
+		case "Target.createTarget":
+			result["targetId"] = "target"
+		case "Target.attachToTarget":
+			sessions++
+			result["sessionId"] = fmt.Sprintf("session%d", sessions)
+		case "Network.setExtraHTTPHeaders":
+			values := make(map[string]string)
+			for key, value := range request.Params["headers"].(map[string]interface{}) {
+				values[key] = value.(string)
+			}
+			headers[request.SessionID] = values
+		case "Page.navigate":
+			status, html := d.load(request.Params["url"].(string), headers[request.SessionID])
+			documents[request.SessionID] = html
+			result["frameId"] = "frame"
+			events = []cdpMessage{
+				{Method: "Network.requestWillBeSent", Params: json.RawMessage(`{"requestId":"document"}`)},
+				{Method: "Network.responseReceived", Params: json.RawMessage(fmt.Sprintf(`{"requestId":"document","frameId":"frame","type":"Document","response":{"status":%d}}`, status))},
+				{Method: "Network.loadingFinished", Params: json.RawMessage(`{"requestId":"document"}`)},
+				{Method: "Page.loadEventFired", Params: json.RawMessage(`{}`)},
+			}
+		case "Runtime.evaluate":
+			html := documents[request.SessionID]
+			expression := request.Params["expression"].(string)
+			if expression == "document.documentElement.outerHTML" {
+				result["result"] = map[string]interface{}{"value": html}
+				break
+			}
+
+			// selectors are ids, e.g. document.querySelector("#ready") !== null
+			var selector string
+			json.Unmarshal([]byte(strings.TrimSuffix(strings.TrimPrefix(expression, "document.querySelector("), ") !== null")), &selector)
+			result["result"] = map[string]interface{}{"value": strings.Contains(html, `id="`+strings.TrimPrefix(selector, "#")+`"`)}
+		}
+
+		err = conn.WriteJSON(map[string]interface{}{"id": request.ID, "sessionId": request.SessionID, "result": result})
+		if err != nil {
+			return
+		}
+
+		for _, event := range events {
+			event.SessionID = request.SessionID
+			err = conn.WriteJSON(event)
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// load get page like a browser would, returning status and body
+func (d *devTools) load(url string, headers map[string]string) (int, string) {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return http.StatusBadRequest, ""
+	}
+
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return http.StatusBadGateway, ""
+	}
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(response.Body)
+	return response.StatusCode, "<html><head></head><body>" + string(body) + "</body></html>"
+}
+
+// newStaticServer serve fixed pages, / echoes the X-Token header, /broken fails and others are not found
+func newStaticServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprintf(w, `<a>1</a><a>2</a><div id="ready">%s</div>`, r.Header.Get("X-Token"))
+		case "/broken":
+			http.Error(w, "broken", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestConnectBrowser(t *testing.T) {
+	tools := newDevTools()
+	defer tools.server.Close()
+	defer CloseBrowsers()
+
+	first, err := getBrowser(tools.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// render actions share the browser of the same option
+	second, err := getBrowser(tools.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tools.mutex.Lock()
+	connections := tools.connections
+	tools.mutex.Unlock()
+	if first != second || connections != 1 {
+		t.Errorf("got %d connections, expect the browser shared", connections)
+	}
+
+	CloseBrowsers()
+	select {
+	case <-first.closed:
+	case <-time.After(time.Second):
+		t.Fatal("browser connection still open after close")
+	}
+
+	// a closed browser is replaced on next use
+	third, err := getBrowser(tools.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if third == first {
+		t.Error("got closed browser, expect a new one")
+	}
+}
+
+func TestConnectBrowserFailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "not json")
+	}))
+	defer server.Close()
+
+	_, err := connectBrowser(server.URL)
+	if err == nil || !strings.Contains(err.Error(), "read devtools version") {
+		t.Errorf("got %v, expect devtools version error", err)
+	}
+}
@@ -0,0 +1,322 @@
+package jobs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// httpClientsKey top-level table of named http client settings
+	httpClientsKey = "http_clients"
+	// defaultHTTPClient client used by actions without a client option
+	defaultHTTPClient = "default"
+
+	// rotation of user agents, besides round robin
+	rotationRandom = "random"
+
+	defaultMaxRedirects = 10
+)
+
+// httpClient http client settings shared by actions referencing it
+//
+//	[http_clients.default]
+//	timeout = "30s"
+//	max_redirects = 5
+//	user_agents = ["Mozilla/5.0 ...", "Mozilla/5.0 ..."]
+//
+// every proxy gets its own transport, so connections are never shared between proxies
+type httpClient struct {
+	name         string
+	transport    *http.Transport
+	timeout      time.Duration
+	maxRedirects int
+	userAgents   []string
+	// random picks user agents at random, nil rotates them in turn
+	random    *rand.Rand
+	nextAgent int
+	clients   map[*proxy]*http.Client
+	mutex     sync.Mutex
+}
+
+// tlsVersions min_tls_version values
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// directClient client of actions without client option when no default client is configured
+var directClient, _ = newHTTPClient(defaultHTTPClient, Config{})
+
+// readHTTPClients remove http clients table from config and create its clients
+func readHTTPClients(c Config) (map[string]*httpClient, error) {
+	value, found := c[httpClientsKey]
+	if !found {
+		return nil, nil
+	}
+	delete(c, httpClientsKey)
+
+	tables, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: expect a table of http clients", httpClientsKey)
+	}
+
+	clients := make(map[string]*httpClient, len(tables))
+	for name, value := range tables {
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s.%s: expect a table", httpClientsKey, name)
+		}
+
+		client, err := newHTTPClient(name, Config(table))
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", httpClientsKey, name, err)
+		}
+
+		clients[name] = client
+	}
+
+	return clients, nil
+}
+
+// newHTTPClient create client from config, unset options keep the defaults of net/http
+func newHTTPClient(name string, c Config) (*httpClient, error) {
+	dialer := &net.Dialer{
+		Timeout:   c.DurationDefault("connect_timeout", 30*time.Second),
+		KeepAlive: c.DurationDefault("keep_alive", 30*time.Second),
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = c.DurationDefault("tls_handshake_timeout", transport.TLSHandshakeTimeout)
+	transport.ResponseHeaderTimeout = c.DurationDefault("response_header_timeout", 0)
+	transport.IdleConnTimeout = c.DurationDefault("idle_conn_timeout", transport.IdleConnTimeout)
+	transport.DisableKeepAlives = c.BoolDefault("disable_keep_alives", false)
+	transport.MaxIdleConns = c.IntDefault("max_idle_conns", transport.MaxIdleConns)
+	transport.MaxIdleConnsPerHost = c.IntDefault("max_idle_conns_per_host", http.DefaultMaxIdleConnsPerHost)
+	transport.MaxConnsPerHost = c.IntDefault("max_conns_per_host", 0)
+	// handles gzip, deflate and brotli itself
+	transport.DisableCompression = true
+
+	tlsConfig, err := newTLSConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	transport.ForceAttemptHTTP2 = c.BoolDefault("http2", true)
+	if !transport.ForceAttemptHTTP2 {
+		// a non-nil empty map turns off http/2
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	client := &httpClient{
+		name:         name,
+		transport:    transport,
+		timeout:      c.DurationDefault("timeout", 0),
+		maxRedirects: c.IntDefault("max_redirects", defaultMaxRedirects),
+		clients:      make(map[*proxy]*http.Client),
+	}
+
+	if _, found := c["user_agents"]; found {
+		client.userAgents, err = c.Strings("user_agents")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch rotation := c.StringDefault("user_agent_rotation", rotationRoundRobin); rotation {
+	case rotationRoundRobin:
+	case rotationRandom:
+		client.random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	default:
+		return nil, fmt.Errorf("invalid user agent rotation [%s], expect %s or %s", rotation, rotationRoundRobin, rotationRandom)
+	}
+
+	return client, nil
+}
+
+// newTLSConfig create tls config from ca, client certificate and verification options
+func newTLSConfig(c Config) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: c.BoolDefault("insecure_skip_verify", false),
+		ServerName:         c.StringDefault("server_name", ""),
+	}
+
+	if version := c.StringDefault("min_tls_version", ""); version != "" {
+		value, found := tlsVersions[version]
+		if !found {
+			return nil, fmt.Errorf("invalid min tls version [%s], expect 1.0, 1.1, 1.2 or 1.3", version)
+		}
+		config.MinVersion = value
+	}
+
+	if path := c.StringDefault("ca_file", ""); path != "" {
+		pem, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", path)
+		}
+		config.RootCAs = pool
+	}
+
+	certFile, keyFile := c.StringDefault("cert_file", ""), c.StringDefault("key_file", "")
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("cert_file and key_file must be set together")
+	}
+
+	if certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
+
+// clientFor get http client sending requests through proxy, nil proxy
+// connects directly or through the proxy of the environment
+func (c *httpClient) clientFor(p *proxy) *http.Client {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	client, found := c.clients[p]
+	if found {
+		return client
+	}
+
+	transport := c.transport.Clone()
+	if p != nil {
+		transport.Proxy = http.ProxyURL(p.url)
+	}
+
+	client = &http.Client{Transport: transport, Timeout: c.timeout, CheckRedirect: c.checkRedirect}
+	c.clients[p] = client
+
+	return client
+}
+
+// checkRedirect follow at most max redirects, 0 returns the redirect response itself
+func (c *httpClient) checkRedirect(request *http.Request, via []*http.Request) error {
+	if c.maxRedirects == 0 {
+		return http.ErrUseLastResponse
+	}
+
+	if len(via) > c.maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", c.maxRedirects)
+	}
+
+	return nil
+}
+
+// userAgent get next user agent, empty if none is configured
+func (c *httpClient) userAgent() string {
+	if len(c.userAgents) == 0 {
+		return ""
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.random != nil {
+		return c.userAgents[c.random.Intn(len(c.userAgents))]
+	}
+
+	agent := c.userAgents[c.nextAgent%len(c.userAgents)]
+	c.nextAgent++
+
+	return agent
+}
+
+// httpClientOf resolve client option of an action: a client name, or empty
+// for the default client if there is one
+func httpClientOf(option string, clients map[string]*httpClient) (*httpClient, error) {
+	if option == "" {
+		if client, found := clients[defaultHTTPClient]; found {
+			return client, nil
+		}
+		return directClient, nil
+	}
+
+	client, found := clients[option]
+	if !found {
+		return nil, fmt.Errorf("http client [%s] not defined in %s", option, httpClientsKey)
+	}
+
+	return client, nil
+}
+
+// resources named settings of a job file actions refer to
+type resources struct {
+	proxies map[string]*proxyPool
+	clients map[string]*httpClient
+	retry   retryPolicy
+}
+
+// readResources remove resource tables from config and create them
+func readResources(c Config) (*resources, error) {
+	proxies, err := readProxies(c)
+	if err != nil {
+		return nil, err
+	}
+
+	clients, err := readHTTPClients(c)
+	if err != nil {
+		return nil, err
+	}
+
+	retry, err := readRetryPolicy(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resources{proxies: proxies, clients: clients, retry: retry}, nil
+}
+
+// resourceAction action referring to named resources
+type resourceAction interface {
+	useResources(r *resources) error
+}
+
+// setResources resolve resources of actions in job tree
+func setResources(jobs []*Job, r *resources) error {
+	for _, job := range jobs {
+		if action, ok := job.Action.(resourceAction); ok {
+			err := action.useResources(r)
+			if err != nil {
+				return fmt.Errorf("%s: %w", job.Path, err)
+			}
+		}
+
+		err := setResources(job.Jobs, r)
+		if err != nil {
+			return err
+		}
+
+		err = setResources(job.ElseJobs, r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
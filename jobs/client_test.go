@@ -0,0 +1,252 @@
+package jobs
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newEchoServer start server answering with the user agent and token header,
+// /redirect/N redirects N times before answering
+func newEchoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(echo))
+}
+
+// echo answer with the user agent and token header of request
+func echo(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/redirect/") {
+		count, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/redirect/"))
+		if count > 0 {
+			http.Redirect(w, r, fmt.Sprintf("/redirect/%d", count-1), http.StatusFound)
+			return
+		}
+	}
+
+	fmt.Fprintf(w, "<a>%s</a><b>%s</b>", r.UserAgent(), r.Header.Get("X-Token"))
+}
+
+// newClientFetch create fetch action of url with http clients, capturing agent and token
+func newClientFetch(t *testing.T, url string, clients map[string]interface{}, options Config) (*Fetch, error) {
+	resources, err := readResources(Config{
+		httpClientsKey: clients,
+		retryPolicyKey: map[string]interface{}{"retry": int64(0)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	config := Config{"url": url, "regexp": "<a>(.*)</a><b>(.*)</b>", "sets": []interface{}{"agent", "token"}, "url_set": "final"}
+	for key, value := range options {
+		config[key] = value
+	}
+
+	job, err := (&Config{}).toJob("fetch", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = setResources([]*Job{job}, resources)
+	if err != nil {
+		return nil, err
+	}
+
+	return job.Action.(*Fetch), nil
+}
+
+// fetchValue fetch once and get a captured value
+func fetchValue(action *Fetch, ctx *Context, key string) (string, error) {
+	ctxs, err := action.Do(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if len(ctxs) != 1 {
+		return "", fmt.Errorf("got %d matches, expect 1", len(ctxs))
+	}
+
+	return ctxs[0].String(key)
+}
+
+func TestClientRedirects(t *testing.T) {
+	server := newEchoServer()
+	defer server.Close()
+
+	tests := []struct {
+		maxRedirects int64
+		redirects    int
+		status       []interface{}
+		// final path answering, empty when the redirects are refused
+		final string
+	}{
+		{2, 2, nil, "/redirect/0"},
+		{2, 3, nil, ""},
+		{0, 1, nil, ""},
+		// the redirect response itself is accepted when its status is
+		{0, 1, []interface{}{"3xx"}, "/redirect/1"},
+	}
+
+	for _, test := range tests {
+		clients := map[string]interface{}{"default": map[string]interface{}{"max_redirects": test.maxRedirects}}
+		options := Config{"invalid": invalidFail}
+		if test.status != nil {
+			// the redirect response has no match
+			options = Config{"status": test.status, "regexp": "(?s)(.+)", "sets": []interface{}{"body"}}
+		}
+
+		action, err := newClientFetch(t, fmt.Sprintf("%s/redirect/%d", server.URL, test.redirects), clients, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		final, err := fetchValue(action, NewContext(), "final")
+		if test.final == "" {
+			if err == nil {
+				t.Errorf("max redirects %d, %d redirects: got no error", test.maxRedirects, test.redirects)
+			}
+			continue
+		}
+
+		if err != nil || final != server.URL+test.final {
+			t.Errorf("max redirects %d, %d redirects: got %s and error %v, expect %s", test.maxRedirects, test.redirects, final, err, server.URL+test.final)
+		}
+	}
+}
+
+func TestClientHeaders(t *testing.T) {
+	server := newEchoServer()
+	defer server.Close()
+
+	clients := map[string]interface{}{
+		"default": map[string]interface{}{"user_agents": []interface{}{"first"}},
+		"rotate":  map[string]interface{}{"user_agents": []interface{}{"a", "b", "c"}},
+		"random":  map[string]interface{}{"user_agents": []interface{}{"a", "b", "c"}, "user_agent_rotation": rotationRandom},
+	}
+
+	ctx := NewContext()
+	ctx.Set("token", "secret")
+
+	fetch := func(options Config, times int) []string {
+		action, err := newClientFetch(t, server.URL, clients, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var agents []string
+		for index := 0; index < times; index++ {
+			agent, err := fetchValue(action, ctx, "agent")
+			if err != nil {
+				t.Fatal(err)
+			}
+			agents = append(agents, agent)
+		}
+
+		return agents
+	}
+
+	// without client option the default client is used
+	if agents := fetch(nil, 2); fmt.Sprint(agents) != "[first first]" {
+		t.Errorf("default client: got agents %v, expect [first first]", agents)
+	}
+
+	if agents := fetch(Config{"client": "rotate"}, 4); fmt.Sprint(agents) != "[a b c a]" {
+		t.Errorf("round robin: got agents %v, expect [a b c a]", agents)
+	}
+
+	seen := make(map[string]bool)
+	for _, agent := range fetch(Config{"client": "random"}, 60) {
+		seen[agent] = true
+	}
+	if len(seen) != 3 || !seen["a"] || !seen["b"] || !seen["c"] {
+		t.Errorf("random: got agents %v, expect every agent of the client", seen)
+	}
+
+	// a user agent header of the action is not rotated
+	headers := map[string]interface{}{"User-Agent": "mine", "X-Token": "${token}"}
+	if agents := fetch(Config{"client": "rotate", "headers": headers}, 2); fmt.Sprint(agents) != "[mine mine]" {
+		t.Errorf("user agent header: got agents %v, expect [mine mine]", agents)
+	}
+
+	action, err := newClientFetch(t, server.URL, clients, Config{"headers": headers})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token, err := fetchValue(action, ctx, "token"); err != nil || token != "secret" {
+		t.Errorf("expanded header: got %s and error %v, expect secret", token, err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		clients map[string]interface{}
+		options Config
+		expect  string
+	}{
+		{"unknown client", nil, Config{"client": "missing"}, "http client [missing] not defined"},
+		{"unknown client of defined ones", map[string]interface{}{"default": map[string]interface{}{}}, Config{"client": "missing"}, "http client [missing] not defined"},
+		{"invalid rotation", map[string]interface{}{"default": map[string]interface{}{"user_agent_rotation": "shuffle"}}, nil, "invalid user agent rotation [shuffle]"},
+		{"invalid tls version", map[string]interface{}{"default": map[string]interface{}{"min_tls_version": "1.4"}}, nil, "invalid min tls version [1.4]"},
+		{"cert without key", map[string]interface{}{"default": map[string]interface{}{"cert_file": "client.pem"}}, nil, "cert_file and key_file must be set together"},
+		{"missing ca file", map[string]interface{}{"default": map[string]interface{}{"ca_file": "missing.pem"}}, nil, "missing.pem"},
+	}
+
+	for _, test := range tests {
+		_, err := newClientFetch(t, "http://site.test/", test.clients, test.options)
+		if err == nil || !strings.Contains(err.Error(), test.expect) {
+			t.Errorf("%s: got error %v, expect %s", test.name, err, test.expect)
+		}
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(echo))
+	// refused handshakes are expected
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := filepath.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		client map[string]interface{}
+		fail   bool
+	}{
+		{"unknown authority", map[string]interface{}{}, true},
+		{"ca file", map[string]interface{}{"ca_file": ca}, false},
+		{"skip verify", map[string]interface{}{"insecure_skip_verify": true}, false},
+		{"server name", map[string]interface{}{"ca_file": ca, "server_name": "other.test"}, true},
+		{"http/1.1 only", map[string]interface{}{"ca_file": ca, "http2": false, "min_tls_version": "1.2"}, false},
+	}
+
+	for _, test := range tests {
+		action, err := newClientFetch(t, server.URL, map[string]interface{}{"default": test.client}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = fetchValue(action, NewContext(), "agent")
+		if fail := err != nil; fail != test.fail {
+			t.Errorf("%s: got error %v, expect failure %v", test.name, err, test.fail)
+		}
+	}
+}
@@ -5,9 +5,9 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"sort"
 	"time"
 
-	"github.com/BurntSushi/toml"
 	"go.uber.org/zap"
 )
 
@@ -16,10 +16,15 @@ var (
 	ErrKeyNotFound = errors.New("key not found")
 )
 
+// stepsKey key of ordered job tables
+const stepsKey = "steps"
+
 // Config job config
 type Config map[string]interface{}
 
-// ReadFile read jobs from toml file
+// ReadFile read jobs from toml, yaml or json file chosen by extension
+//
+// included files are merged first and templates are injected into use actions
 func ReadFile(filePath string) ([]*Job, error) {
 	_, err := os.Stat(filePath)
 	if err != nil {
@@ -27,15 +32,36 @@ func ReadFile(filePath string) ([]*Job, error) {
 		return nil, err
 	}
 
-	// unmarshal toml
-	c := new(Config)
-	_, err = toml.DecodeFile(filePath, c)
+	c, err := loadFile(filePath, nil)
 	if err != nil {
 		zap.L().Error("unmarshal job file failed", zap.Error(err), zap.String("path", filePath))
 		return nil, err
 	}
 
-	return c.ToJobs()
+	resources, err := readResources(c)
+	if err != nil {
+		return nil, err
+	}
+
+	err = resolveTemplates(c)
+	if err != nil {
+		zap.L().Error("resolve templates failed", zap.Error(err), zap.String("path", filePath))
+		return nil, err
+	}
+
+	jobs, err := c.ToJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	setPaths("", jobs)
+
+	err = setResources(jobs, resources)
+	if err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
 }
 
 // Get get raw value
@@ -43,7 +69,7 @@ func (c Config) Get(key string) (interface{}, error) {
 	value, found := c[key]
 	if !found {
 		// zap.L().Error("key not found", zap.String("key", key))
-		return "", ErrKeyNotFound
+		return "", fmt.Errorf("%w: %s", ErrKeyNotFound, key)
 	}
 
 	return value, nil
@@ -101,6 +127,34 @@ func (c Config) IntDefault(key string, defaultValue int) int {
 	return value
 }
 
+// Float get float value, integers are converted
+func (c Config) Float(key string) (float64, error) {
+	v, err := c.Get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	switch value := v.(type) {
+	case float64:
+		return value, nil
+	case int64:
+		return float64(value), nil
+	}
+
+	zap.L().Error("invalid value type", zap.String("key", key), zap.Any("value", v))
+	return 0, fmt.Errorf("key [%s] value %+v is not a float, type:%s", key, v, reflect.TypeOf(v))
+}
+
+// FloatDefault get float value or default
+func (c Config) FloatDefault(key string, defaultValue float64) float64 {
+	value, err := c.Float(key)
+	if err != nil {
+		return defaultValue
+	}
+
+	return value
+}
+
 // Bool get boolean value
 func (c Config) Bool(key string) (bool, error) {
 	v, err := c.Get(key)
@@ -179,6 +233,33 @@ func (c Config) Strings(key string) ([]string, error) {
 	return values, nil
 }
 
+// Ints get int slice
+func (c Config) Ints(key string) ([]int, error) {
+	v, err := c.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	array, ok := v.([]interface{})
+	if !ok {
+		zap.L().Error("invalid value type", zap.String("key", key), zap.Any("value", v))
+		return nil, fmt.Errorf("key [%s] value %+v is not a array", key, v)
+	}
+
+	values := make([]int, len(array))
+	for index, value := range array {
+		intValue, ok := value.(int64)
+		if !ok {
+			zap.L().Error("invalid value type", zap.String("key", key), zap.Any("value", value))
+			return nil, fmt.Errorf("key [%s] value %+v is not a int", key, v)
+		}
+
+		values[index] = int(intValue)
+	}
+
+	return values, nil
+}
+
 // Map get map
 func (c Config) Map(key string) (map[string]string, error) {
 	v, err := c.Get(key)
@@ -211,19 +292,52 @@ func (c Config) MapDefault(key string) map[string]string {
 	return value
 }
 
-// ToJobs parse config to jobs
+// sortedKeys get sorted config keys
+func sortedKeys(c Config) []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// ToJobs parse config to jobs, sibling jobs are sorted by action name
+//
+// the steps key holds an array of job tables which keep their order,
+// e.g. [[steps]] in toml or a list of single action maps in yaml and json
 func (c Config) ToJobs() ([]*Job, error) {
 	var jobs []*Job
-	for key, value := range c {
+	for _, key := range sortedKeys(c) {
+		value := c[key]
+		if key == stepsKey {
+			steps, ok := value.([]map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: expect an array of job tables", key)
+			}
+
+			for index, step := range steps {
+				stepJobs, err := Config(step).ToJobs()
+				if err != nil {
+					return nil, wrapPath(fmt.Sprintf("%s[%d]", key, index), err)
+				}
+
+				jobs = append(jobs, stepJobs...)
+			}
+			continue
+		}
+
 		object, ok := value.(map[string]interface{})
 		if ok {
 			config := Config(object)
 			job, err := c.toJob(key, &config)
 			if err != nil {
-				return nil, err
+				return nil, wrapPath(key, err)
 			}
 
 			if job != nil {
+				job.Name = key
 				jobs = append(jobs, job)
 			}
 			continue
@@ -235,10 +349,11 @@ func (c Config) ToJobs() ([]*Job, error) {
 				config := Config(object)
 				job, err := c.toJob(key, &config)
 				if err != nil {
-					return nil, err
+					return nil, wrapPath(key, err)
 				}
 
 				if job != nil {
+					job.Name = key
 					jobs = append(jobs, job)
 				}
 			}
@@ -253,12 +368,14 @@ func (c *Config) toJob(key string, conf *Config) (*Job, error) {
 	switch key {
 	case "fetch":
 		return conf.toConditionJob(newFetch, (*c)["fetch_else"])
+	case "render":
+		return conf.toConditionJob(newRender, (*c)["render_else"])
 	case "match":
 		return conf.toConditionJob(newMatch, (*c)["match_else"])
 	case "range":
 		return conf.toSequenceJob(newRange)
 	case "execute":
-		return conf.toSequenceJob(newExecute)
+		return conf.toConditionJob(newExecute, (*c)["execute_else"])
 	case "replace":
 		return conf.toSequenceJob(newReplace)
 	case "exists":
@@ -279,14 +396,21 @@ func (c *Config) toJob(key string, conf *Config) (*Job, error) {
 		return conf.toSequenceJob(newCosUpload)
 	case "cos_download":
 		return conf.toSequenceJob(newCosDownload)
-	case "fetch_else", "match_else", "exists_else", "headers":
+	case useKey:
+		return conf.toSequenceJob(newUse)
+	case "fetch_else", "render_else", "match_else", "exists_else", "execute_else", "headers", "params", "env":
 		return nil, nil
 	default:
 		zap.L().Error("invalid action", zap.String("action", key))
-		return nil, ErrInvalidAction
+		return nil, fmt.Errorf("%w%s", ErrInvalidAction, suggest(key, actionNames()))
 	}
 }
 
+// wrapPath prefix error with config key, nested keys form the path of the action
+func wrapPath(key string, err error) error {
+	return fmt.Errorf("%s: %w", key, err)
+}
+
 // toSequenceJob parse config to else jobs
 func (c *Config) toSequenceJob(fun func(c *Config) (interface{}, error)) (*Job, error) {
 	action, err := fun(c)
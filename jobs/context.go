@@ -1,68 +1,282 @@
 package jobs
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"os"
+	"reflect"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/nzai/crawl/constants"
+	"github.com/nzai/crawl/tracing"
 	"go.uber.org/zap"
 )
 
 // Context job context
-type Context map[string]string
+//
+// values are typed: string, int, float64, bool, []interface{} and
+// map[string]interface{}, lists and maps can be nested and are
+// reachable by dotted path, e.g. item.author.name or item.tags.0
+//
+// contexts form a copy-on-write scope chain: a clone only stores the keys
+// set on itself and reads the rest from its parent, so parent values must
+// not be changed while children are alive
+type Context struct {
+	parent    *Context
+	values    map[string]interface{}
+	strict    bool
+	depth     int
+	scheduler *Scheduler
+	slot      bool
+	plan      *Plan
+	cancel    context.Context
+	logger    *zap.Logger
+	progress  *Progress
+	span      *tracing.Span
+	// scope context values are written to, set on views of a context
+	scope *Context
+}
+
+// NewContext create empty context
+func NewContext() *Context {
+	return &Context{}
+}
 
 // NewContextFromEnv create context from env
+//
+// environment variables with the CRAWL_ prefix are imported without the prefix
+// in lower case, e.g. CRAWL_BUCKET as bucket, root is always the given root
 func NewContextFromEnv(root string) *Context {
-	ctx := Context(make(map[string]string))
+	ctx := NewContext()
+
+	for _, line := range os.Environ() {
+		index := strings.Index(line, "=")
+		if index < 0 || !strings.HasPrefix(line[:index], constants.EnvPrefix) {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimPrefix(line[:index], constants.EnvPrefix))
+		if key != "" {
+			ctx.Set(key, line[index+1:])
+		}
+	}
 	ctx.Set("root", root)
 
-	// lines := os.Environ()
-	// for _, line := range lines {
-	// 	index := strings.Index(line, "=")
-	// 	if index < 0 {
-	// 		continue
-	// 	}
+	return ctx
+}
+
+// Clone create child context
+func (c *Context) Clone() *Context {
+	return &Context{
+		parent:    c,
+		strict:    c.strict,
+		depth:     c.depth + 1,
+		scheduler: c.scheduler,
+		plan:      c.plan,
+		cancel:    c.cancel,
+		logger:    c.logger,
+		progress:  c.progress,
+		span:      c.span,
+	}
+}
 
-	// 	ctx.Set(line[:index], line[index+1:])
-	// }
+// inline create child context running in the branch of context, unlike a
+// clone it stays at the same depth and keeps the slot of the branch
+func (c *Context) inline() *Context {
+	return &Context{
+		parent:    c,
+		strict:    c.strict,
+		depth:     c.depth,
+		scheduler: c.scheduler,
+		slot:      c.slot,
+		plan:      c.plan,
+		cancel:    c.cancel,
+		logger:    c.logger,
+		progress:  c.progress,
+		span:      c.span,
+	}
+}
+
+// SetStrict set strict mode, referencing undefined variable is an error in strict mode
+func (c *Context) SetStrict(strict bool) {
+	c.strict = strict
+}
 
-	return &ctx
+// SetScheduler set run-wide branch scheduler
+func (c *Context) SetScheduler(scheduler *Scheduler) {
+	c.scheduler = scheduler
 }
 
-// Clone clone context
-func (c Context) Clone() *Context {
-	ctx := Context(make(map[string]string))
-	for key, value := range c {
-		ctx.Set(key, value)
+// SetCancel stop jobs once cancel is done
+func (c *Context) SetCancel(cancel context.Context) {
+	c.cancel = cancel
+}
+
+// Err get cancellation error, nil if jobs are not canceled
+func (c *Context) Err() error {
+	if c.cancel == nil {
+		return nil
+	}
+
+	return c.cancel.Err()
+}
+
+// done get cancellation context for external calls
+func (c *Context) done() context.Context {
+	if c.cancel == nil {
+		return context.Background()
 	}
 
-	return &ctx
+	return c.cancel
+}
+
+// SetLogger set logger of jobs, e.g. to capture the log of a run
+func (c *Context) SetLogger(logger *zap.Logger) {
+	c.logger = logger
+}
+
+// L get logger of jobs, the global logger by default
+func (c *Context) L() *zap.Logger {
+	if c.logger == nil {
+		return zap.L()
+	}
+
+	return c.logger
 }
 
 // Set set key value
-func (c Context) Set(key, value string) {
-	c[key] = value
+func (c *Context) Set(key, value string) {
+	c.set(key, value)
+}
+
+// SetValue set key typed value
+func (c *Context) SetValue(key string, value interface{}) {
+	c.set(key, normalizeValue(value))
+}
+
+func (c *Context) set(key string, value interface{}) {
+	if c.scope != nil {
+		c.scope.set(key, value)
+		return
+	}
+
+	if c.values == nil {
+		c.values = make(map[string]interface{})
+	}
+
+	c.values[key] = value
+}
+
+// Values get all values visible from context
+func (c *Context) Values() map[string]interface{} {
+	values := make(map[string]interface{})
+	for ctx := c; ctx != nil; ctx = ctx.parent {
+		for key, value := range ctx.values {
+			if _, found := values[key]; !found {
+				values[key] = value
+			}
+		}
+	}
+
+	return values
+}
+
+// local get value by key from the nearest scope
+func (c *Context) local(key string) (interface{}, bool) {
+	for ctx := c; ctx != nil; ctx = ctx.parent {
+		value, found := ctx.values[key]
+		if found {
+			return value, true
+		}
+	}
+
+	return nil, false
+}
+
+// Get get typed value by key or dotted path
+func (c *Context) Get(key string) (interface{}, bool) {
+	value, found := c.local(key)
+	if found {
+		return value, true
+	}
+
+	parts := strings.Split(key, ".")
+	if len(parts) == 1 {
+		return nil, false
+	}
+
+	value, found = c.local(parts[0])
+	if !found {
+		return nil, false
+	}
+
+	for _, part := range parts[1:] {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value, found = v[part]
+			if !found {
+				return nil, false
+			}
+		case []interface{}:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, false
+			}
+
+			value = v[index]
+		default:
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// String get value as string
+func (c *Context) String(key string) (string, error) {
+	value, found := c.Get(key)
+	if !found {
+		return "", ErrKeyNotFound
+	}
+
+	return formatValue(value), nil
 }
 
 // Int get int value
-func (c Context) Int(key string) (int, error) {
-	value, found := c[key]
+func (c *Context) Int(key string) (int, error) {
+	value, found := c.Get(key)
 	if !found {
 		return 0, ErrKeyNotFound
 	}
 
-	intValue, err := strconv.Atoi(value)
-	if err != nil {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	case string:
+		intValue, err := strconv.Atoi(v)
+		if err == nil {
+			return intValue, nil
+		}
+
 		zap.L().Error("parse value to int failed",
 			zap.Error(err),
-			zap.String("value", value))
+			zap.String("value", v))
 		return 0, err
 	}
 
-	return intValue, nil
+	zap.L().Error("invalid value type", zap.String("key", key), zap.Any("value", value))
+	return 0, fmt.Errorf("key [%s] value %+v is not a int, type:%s", key, value, reflect.TypeOf(value))
 }
 
 // IntDefault get int value or default
-func (c Context) IntDefault(key string, defaultValue int) int {
+func (c *Context) IntDefault(key string, defaultValue int) int {
 	value, err := c.Int(key)
 	if err != nil {
 		return defaultValue
@@ -71,14 +285,172 @@ func (c Context) IntDefault(key string, defaultValue int) int {
 	return value
 }
 
-// Expand expand expression by context
-func (c Context) Expand(expression string) string {
-	return os.Expand(expression, func(key string) string {
-		value, found := c[key]
-		if !found {
-			return key
+// Float get float value
+func (c *Context) Float(key string) (float64, error) {
+	value, found := c.Get(key)
+	if !found {
+		return 0, ErrKeyNotFound
+	}
+
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case string:
+		floatValue, err := strconv.ParseFloat(v, 64)
+		if err == nil {
+			return floatValue, nil
+		}
+
+		zap.L().Error("parse value to float failed",
+			zap.Error(err),
+			zap.String("value", v))
+		return 0, err
+	}
+
+	zap.L().Error("invalid value type", zap.String("key", key), zap.Any("value", value))
+	return 0, fmt.Errorf("key [%s] value %+v is not a float, type:%s", key, value, reflect.TypeOf(value))
+}
+
+// Bool get boolean value
+func (c *Context) Bool(key string) (bool, error) {
+	value, found := c.Get(key)
+	if !found {
+		return false, ErrKeyNotFound
+	}
+
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		boolValue, err := strconv.ParseBool(v)
+		if err == nil {
+			return boolValue, nil
+		}
+
+		zap.L().Error("parse value to bool failed",
+			zap.Error(err),
+			zap.String("value", v))
+		return false, err
+	}
+
+	zap.L().Error("invalid value type", zap.String("key", key), zap.Any("value", value))
+	return false, fmt.Errorf("key [%s] value %+v is not a bool, type:%s", key, value, reflect.TypeOf(value))
+}
+
+// BoolDefault get boolean value or default
+func (c *Context) BoolDefault(key string, defaultValue bool) bool {
+	value, err := c.Bool(key)
+	if err != nil {
+		return defaultValue
+	}
+
+	return value
+}
+
+// Expand expand expression by context, see expandTemplate for syntax
+func (c *Context) Expand(expression string) (string, error) {
+	return c.expandTemplate(expression)
+}
+
+// normalizeValue convert value to one of the context value types
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil, string, int, float64, bool:
+		return v
+	case int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return int(reflect.ValueOf(v).Convert(reflect.TypeOf(0)).Int())
+	case float32:
+		return float64(v)
+	case []string:
+		list := make([]interface{}, len(v))
+		for index, item := range v {
+			list[index] = item
+		}
+		return list
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for index, item := range v {
+			list[index] = normalizeValue(item)
+		}
+		return list
+	case []map[string]interface{}:
+		list := make([]interface{}, len(v))
+		for index, item := range v {
+			list[index] = normalizeValue(item)
+		}
+		return list
+	case map[string]string:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = item
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = normalizeValue(item)
+		}
+		return m
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// capturedValue typed value of captured text, integers and decimals written
+// the way formatValue writes them become numbers, everything else stays a
+// string, so the value always expands back to the captured text
+func capturedValue(text string) interface{} {
+	if intValue, err := strconv.Atoi(text); err == nil && strconv.Itoa(intValue) == text {
+		return intValue
+	}
+
+	if floatValue, err := strconv.ParseFloat(text, 64); err == nil && strconv.FormatFloat(floatValue, 'f', -1, 64) == text {
+		return floatValue
+	}
+
+	return text
+}
+
+// formatValue format context value as string
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		buffer, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
 		}
 
-		return value
-	})
+		return string(buffer)
+	}
+}
+
+// expandSecret resolve credential option: ${env:NAME} reads environment
+// variable NAME, anything else is used as written, so a secret holding $ is
+// never taken for a template
+func expandSecret(value string) (string, error) {
+	if !strings.HasPrefix(value, "${env:") || !strings.HasSuffix(value, "}") {
+		return value, nil
+	}
+
+	name := value[len("${env:") : len(value)-1]
+	secret, found := os.LookupEnv(name)
+	if !found {
+		return "", fmt.Errorf("environment variable %s of credential not set", name)
+	}
+
+	return secret, nil
 }
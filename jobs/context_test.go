@@ -0,0 +1,52 @@
+package jobs
+
+import (
+	"os"
+	"testing"
+)
+
+func TestExpandSecret(t *testing.T) {
+	os.Setenv("CRAWL_TEST_SECRET", "s3cr$t")
+	defer os.Unsetenv("CRAWL_TEST_SECRET")
+
+	tests := []struct {
+		value  string
+		expect string
+	}{
+		{"ab$cd", "ab$cd"},
+		{"${key_secret}", "${key_secret}"},
+		{"${env:CRAWL_TEST_SECRET}", "s3cr$t"},
+	}
+
+	for _, test := range tests {
+		secret, err := expandSecret(test.value)
+		if err != nil {
+			t.Errorf("expand secret %s failed: %v", test.value, err)
+			continue
+		}
+
+		if secret != test.expect {
+			t.Errorf("expand secret %s = %s, expect %s", test.value, secret, test.expect)
+		}
+	}
+
+	_, err := expandSecret("${env:CRAWL_TEST_UNSET}")
+	if err == nil {
+		t.Error("expand secret of unset environment variable should fail")
+	}
+}
+
+func TestNewContextFromEnv(t *testing.T) {
+	os.Setenv("CRAWL_BUCKET", "photos")
+	os.Setenv("CRAWL_ROOT", "/elsewhere")
+	defer os.Unsetenv("CRAWL_BUCKET")
+	defer os.Unsetenv("CRAWL_ROOT")
+
+	ctx := NewContextFromEnv("/jobs")
+	for key, expect := range map[string]string{"bucket": "photos", "root": "/jobs"} {
+		value, err := ctx.String(key)
+		if err != nil || value != expect {
+			t.Errorf("%s = %s, %v, expect %s", key, value, err, expect)
+		}
+	}
+}
@@ -1,9 +1,9 @@
 package jobs
 
 import (
-	"context"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/tencentyun/cos-go-sdk-v5"
@@ -18,6 +18,7 @@ type CosExists struct {
 	key        string
 	toContinue bool
 	debug      bool
+	retrying
 }
 
 // newCosExists create cosExists action
@@ -49,6 +50,11 @@ func newCosExists(c *Config) (interface{}, error) {
 
 	debug := c.BoolDefault("debug", false)
 
+	retrying, err := newRetrying(c)
+	if err != nil {
+		return nil, err
+	}
+
 	return &CosExists{
 		endPoint:   endPoint,
 		keyID:      keyID,
@@ -56,47 +62,71 @@ func newCosExists(c *Config) (interface{}, error) {
 		key:        key,
 		toContinue: _continue,
 		debug:      debug,
+		retrying:   retrying,
 	}, nil
 }
 
+// Plan describe check in plan mode
+func (s CosExists) Plan(ctx *Context) (string, error) {
+	endPoint, err := ctx.Expand(s.endPoint)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := ctx.Expand(s.key)
+	if err != nil {
+		return "", err
+	}
+
+	return "check " + strings.TrimRight(endPoint, "/") + "/" + key + " exists, assume continue", nil
+}
+
+// Attributes describe expanded options for tracing
+func (s CosExists) Attributes(ctx *Context) map[string]string {
+	return map[string]string{
+		"endpoint": expandAttribute(ctx, s.endPoint),
+		"key":      expandAttribute(ctx, s.key),
+	}
+}
+
 // Do do job
 func (s CosExists) Do(ctx *Context) (bool, error) {
-	u, _ := url.Parse(s.endPoint)
-	client := cos.NewClient(&cos.BaseURL{BucketURL: u}, &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &cos.AuthorizationTransport{
-			SecretID:  s.keyID,
-			SecretKey: s.keySecret,
-		},
-	})
+	client, endPoint, err := newCosClient(ctx, s.endPoint, s.keyID, s.keySecret)
+	if err != nil {
+		return false, err
+	}
 
 	exists := false
-	key := ctx.Expand(s.key)
-	response, err := client.Object.Head(context.TODO(), key, nil)
+	key, err := ctx.Expand(s.key)
 	if err != nil {
-		e, ok := err.(*cos.ErrorResponse)
-		if !ok {
-			zap.L().Error("head object failed",
-				zap.Error(err),
-				zap.String("endPoint", s.endPoint),
-				zap.String("key", key))
-			return false, err
-		}
+		return false, err
+	}
 
-		switch e.Response.StatusCode {
-		case http.StatusOK:
+	err = s.retry.run(ctx, "cos_exists", func() error {
+		span := ctx.startSpan("cos.Head", "endpoint", endPoint, "key", key)
+		response, err := client.Object.Head(ctx.done(), key, nil)
+		span.End(err)
+		if err == nil {
+			response.Body.Close()
 			exists = true
-		case http.StatusNotFound:
+			return nil
+		}
+
+		e, ok := err.(*cos.ErrorResponse)
+		if ok && e.Response != nil && e.Response.StatusCode == http.StatusNotFound {
 			exists = false
-		default:
-			zap.L().Error("head object failed",
-				zap.Error(err),
-				zap.String("endPoint", s.endPoint),
-				zap.String("key", key))
-			return false, err
+			return nil
 		}
+
+		return err
+	}, nil, zap.String("endPoint", endPoint), zap.String("key", key))
+	if err != nil {
+		ctx.L().Error("head object failed",
+			zap.Error(err),
+			zap.String("endPoint", endPoint),
+			zap.String("key", key))
+		return false, err
 	}
-	defer response.Body.Close()
 
 	_continue := exists
 	if !s.toContinue {
@@ -109,8 +139,8 @@ func (s CosExists) Do(ctx *Context) (bool, error) {
 			status = "tencent cloud cos object not exists"
 		}
 
-		zap.L().Debug(status,
-			zap.String("endPoint", s.endPoint),
+		ctx.L().Debug(status,
+			zap.String("endPoint", endPoint),
 			zap.String("key", key),
 			zap.Bool("continue", _continue))
 	}
@@ -126,6 +156,7 @@ type CosUpload struct {
 	key       string
 	path      string
 	debug     bool
+	retrying
 }
 
 // newCosUpload create cosUpload action
@@ -157,6 +188,11 @@ func newCosUpload(c *Config) (interface{}, error) {
 
 	debug := c.BoolDefault("debug", false)
 
+	retrying, err := newRetrying(c)
+	if err != nil {
+		return nil, err
+	}
+
 	return &CosUpload{
 		path:      path,
 		endPoint:  endPoint,
@@ -164,47 +200,89 @@ func newCosUpload(c *Config) (interface{}, error) {
 		keySecret: keySecret,
 		key:       key,
 		debug:     debug,
+		retrying:  retrying,
 	}, nil
 }
 
+// Plan describe upload in plan mode
+func (s CosUpload) Plan(ctx *Context) (string, error) {
+	endPoint, err := ctx.Expand(s.endPoint)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := ctx.Expand(s.key)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := ctx.Expand(s.path)
+	if err != nil {
+		return "", err
+	}
+
+	return "upload " + path + " to " + strings.TrimRight(endPoint, "/") + "/" + key, nil
+}
+
+// Attributes describe expanded options for tracing
+func (s CosUpload) Attributes(ctx *Context) map[string]string {
+	return map[string]string{
+		"endpoint": expandAttribute(ctx, s.endPoint),
+		"key":      expandAttribute(ctx, s.key),
+		"path":     expandAttribute(ctx, s.path),
+	}
+}
+
 // Do do job
 func (s CosUpload) Do(ctx *Context) error {
-	u, _ := url.Parse(s.endPoint)
-	client := cos.NewClient(&cos.BaseURL{BucketURL: u}, &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &cos.AuthorizationTransport{
-			SecretID:  s.keyID,
-			SecretKey: s.keySecret,
-		},
-	})
+	client, endPoint, err := newCosClient(ctx, s.endPoint, s.keyID, s.keySecret)
+	if err != nil {
+		return err
+	}
 
-	key := ctx.Expand(s.key)
-	path := ctx.Expand(s.path)
+	key, err := ctx.Expand(s.key)
+	if err != nil {
+		return err
+	}
+
+	path, err := ctx.Expand(s.path)
+	if err != nil {
+		return err
+	}
 
-	_, response, err := client.Object.Upload(context.TODO(), key, path, nil)
+	var response *cos.Response
+	err = s.retry.run(ctx, "cos_upload", func() error {
+		span := ctx.startSpan("cos.Upload", "endpoint", endPoint, "key", key, "path", path)
+		var err error
+		_, response, err = client.Object.Upload(ctx.done(), key, path, nil)
+		span.End(err)
+		return err
+	}, nil, zap.String("endPoint", endPoint), zap.String("key", key), zap.String("path", path))
 	if err != nil {
-		zap.L().Error("upload file to tencent cloud cos bucket failed",
+		ctx.L().Error("upload file to tencent cloud cos bucket failed",
 			zap.Error(err),
 			zap.String("path", path),
-			zap.String("endPoint", s.endPoint),
+			zap.String("endPoint", endPoint),
 			zap.String("key", key))
 		return err
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		zap.L().Error("upload file to tencent cloud cos bucket failed",
+		ctx.L().Error("upload file to tencent cloud cos bucket failed",
 			zap.String("path", path),
-			zap.String("endPoint", s.endPoint),
+			zap.String("endPoint", endPoint),
 			zap.String("key", key),
 			zap.Int("status code", response.StatusCode),
 			zap.String("status text", response.Status))
 	}
 
+	observeFileBytes(backendCos, directionUpload, path)
+
 	if s.debug {
-		zap.L().Debug("upload file to tencent cloud cos bucket success",
+		ctx.L().Debug("upload file to tencent cloud cos bucket success",
 			zap.String("path", path),
-			zap.String("endPoint", s.endPoint),
+			zap.String("endPoint", endPoint),
 			zap.String("key", key))
 	}
 
@@ -219,6 +297,7 @@ type CosDownload struct {
 	key       string
 	path      string
 	debug     bool
+	retrying
 }
 
 // newCosDownload create cosDownload action
@@ -250,6 +329,11 @@ func newCosDownload(c *Config) (interface{}, error) {
 
 	debug := c.BoolDefault("debug", false)
 
+	retrying, err := newRetrying(c)
+	if err != nil {
+		return nil, err
+	}
+
 	return &CosDownload{
 		path:      path,
 		endPoint:  endPoint,
@@ -257,49 +341,121 @@ func newCosDownload(c *Config) (interface{}, error) {
 		keySecret: keySecret,
 		key:       key,
 		debug:     debug,
+		retrying:  retrying,
 	}, nil
 }
 
+// Plan describe download in plan mode
+func (s CosDownload) Plan(ctx *Context) (string, error) {
+	endPoint, err := ctx.Expand(s.endPoint)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := ctx.Expand(s.key)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := ctx.Expand(s.path)
+	if err != nil {
+		return "", err
+	}
+
+	return "download " + strings.TrimRight(endPoint, "/") + "/" + key + " to " + path, nil
+}
+
+// Attributes describe expanded options for tracing
+func (s CosDownload) Attributes(ctx *Context) map[string]string {
+	return map[string]string{
+		"endpoint": expandAttribute(ctx, s.endPoint),
+		"key":      expandAttribute(ctx, s.key),
+		"path":     expandAttribute(ctx, s.path),
+	}
+}
+
 // Do do job
 func (s CosDownload) Do(ctx *Context) error {
-	u, _ := url.Parse(s.endPoint)
-	client := cos.NewClient(&cos.BaseURL{BucketURL: u}, &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &cos.AuthorizationTransport{
-			SecretID:  s.keyID,
-			SecretKey: s.keySecret,
-		},
-	})
+	client, endPoint, err := newCosClient(ctx, s.endPoint, s.keyID, s.keySecret)
+	if err != nil {
+		return err
+	}
 
-	key := ctx.Expand(s.key)
-	path := ctx.Expand(s.path)
+	key, err := ctx.Expand(s.key)
+	if err != nil {
+		return err
+	}
 
-	response, err := client.Object.GetToFile(context.TODO(), key, path, nil)
+	path, err := ctx.Expand(s.path)
 	if err != nil {
-		zap.L().Error("download file from tencent cloud cos bucket failed",
+		return err
+	}
+
+	var response *cos.Response
+	err = s.retry.run(ctx, "cos_download", func() error {
+		span := ctx.startSpan("cos.GetToFile", "endpoint", endPoint, "key", key, "path", path)
+		var err error
+		response, err = client.Object.GetToFile(ctx.done(), key, path, nil)
+		span.End(err)
+		return err
+	}, nil, zap.String("endPoint", endPoint), zap.String("key", key), zap.String("path", path))
+	if err != nil {
+		ctx.L().Error("download file from tencent cloud cos bucket failed",
 			zap.Error(err),
 			zap.String("path", path),
-			zap.String("endPoint", s.endPoint),
+			zap.String("endPoint", endPoint),
 			zap.String("key", key))
 		return err
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		zap.L().Error("download file from tencent cloud cos bucket failed",
+		ctx.L().Error("download file from tencent cloud cos bucket failed",
 			zap.String("path", path),
-			zap.String("endPoint", s.endPoint),
+			zap.String("endPoint", endPoint),
 			zap.String("key", key),
 			zap.Int("status code", response.StatusCode),
 			zap.String("status text", response.Status))
 	}
 
+	observeFileBytes(backendCos, directionDownload, path)
+
 	if s.debug {
-		zap.L().Debug("download file from tencent cloud cos bucket success",
+		ctx.L().Debug("download file from tencent cloud cos bucket success",
 			zap.String("path", path),
-			zap.String("endPoint", s.endPoint),
+			zap.String("endPoint", endPoint),
 			zap.String("key", key))
 	}
 
 	return nil
 }
+
+// newCosClient create client of bucket url endpoint, the endpoint is expanded
+// from context while credentials are only resolved by expandSecret
+func newCosClient(ctx *Context, endPoint, keyID, keySecret string) (*cos.Client, string, error) {
+	endPoint, err := ctx.Expand(endPoint)
+	if err != nil {
+		return nil, "", err
+	}
+
+	keyID, err = expandSecret(keyID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	keySecret, err = expandSecret(keySecret)
+	if err != nil {
+		return nil, "", err
+	}
+
+	u, _ := url.Parse(endPoint)
+	client := cos.NewClient(&cos.BaseURL{BucketURL: u}, &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &cos.AuthorizationTransport{
+			SecretID:  keyID,
+			SecretKey: keySecret,
+		},
+	})
+
+	return client, endPoint, nil
+}
@@ -0,0 +1,159 @@
+package jobs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// acceptEncoding content encodings fetch can decompress
+	acceptEncoding = "gzip, deflate, br"
+	// charsetRaw charset option keeping the body bytes as they are
+	charsetRaw = "raw"
+)
+
+// utf8BOM utf-8 byte order mark, decoders of other charsets turn their own into it
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// validCharset check whether charset option is raw, empty or a known label
+func validCharset(label string) bool {
+	if label == "" || label == charsetRaw {
+		return true
+	}
+
+	e, _ := charset.Lookup(label)
+	return e != nil
+}
+
+// decompress wrap body with decoders of content encodings, applied in reverse
+// order, closing the returned reader closes the decoders
+func decompress(body io.Reader, contentEncoding string) (io.ReadCloser, error) {
+	d := &decoders{Reader: body}
+	encodings := strings.Split(contentEncoding, ",")
+	for index := len(encodings) - 1; index >= 0; index-- {
+		var err error
+		switch encoding := strings.ToLower(strings.TrimSpace(encodings[index])); encoding {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			var reader *gzip.Reader
+			reader, err = gzip.NewReader(d.Reader)
+			if err == nil {
+				d.push(reader, reader)
+			}
+		case "deflate":
+			var reader io.ReadCloser
+			reader, err = newDeflateReader(d.Reader)
+			if err == nil {
+				d.push(reader, reader)
+			}
+		case "br":
+			d.push(brotli.NewReader(d.Reader), nil)
+		default:
+			err = fmt.Errorf("unsupported content encoding: %s", encoding)
+		}
+
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// decoders body read through its content decoders
+type decoders struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// push read through decoder next, closer nil for decoders without one
+func (d *decoders) push(decoder io.Reader, closer io.Closer) {
+	d.Reader = decoder
+	if closer != nil {
+		d.closers = append(d.closers, closer)
+	}
+}
+
+// Close close decoders, the outermost first
+func (d *decoders) Close() error {
+	var err error
+	for index := len(d.closers) - 1; index >= 0; index-- {
+		e := d.closers[index].Close()
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// newDeflateReader read deflate body, zlib wrapped as the rfc says or raw as some servers send it
+func newDeflateReader(body io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(body)
+	header, err := buffered.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+
+	return flate.NewReader(buffered), nil
+}
+
+// decodeCharset convert text body to utf-8, returns the charset it was decoded from
+//
+// an empty label detects the charset from byte order mark, content type and
+// meta tags, other labels override detection, e.g. gbk or shift_jis
+func decodeCharset(body []byte, contentType, label string) ([]byte, string, error) {
+	if label == charsetRaw {
+		return body, "", nil
+	}
+
+	if label == "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		if !isText(mediaType) {
+			return body, "", nil
+		}
+	}
+
+	e, name := charset.Lookup(label)
+	if label == "" {
+		e, name, _ = charset.DetermineEncoding(body, contentType)
+	} else if e == nil {
+		return nil, "", fmt.Errorf("unknown charset: %s", label)
+	}
+
+	if name != "utf-8" {
+		var err error
+		body, err = e.NewDecoder().Bytes(body)
+		if err != nil {
+			return nil, "", fmt.Errorf("decode %s failed: %w", name, err)
+		}
+	}
+
+	return bytes.TrimPrefix(body, utf8BOM), name, nil
+}
+
+// isText check whether media type is text, an empty type counts as text
+func isText(mediaType string) bool {
+	if mediaType == "" || strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+
+	for _, suffix := range []string{"html", "xml", "json", "javascript"} {
+		if strings.HasSuffix(mediaType, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,142 @@
+package jobs
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compress encode text with content encodings in the order they were applied
+func compress(t *testing.T, text string, encodings ...string) []byte {
+	body := []byte(text)
+	for _, encoding := range encodings {
+		var buffer bytes.Buffer
+		var writer io.WriteCloser
+		switch encoding {
+		case "gzip":
+			writer = gzip.NewWriter(&buffer)
+		case "deflate":
+			writer = zlib.NewWriter(&buffer)
+		case "raw deflate":
+			writer, _ = flate.NewWriter(&buffer, flate.DefaultCompression)
+		case "br":
+			writer = brotli.NewWriter(&buffer)
+		}
+
+		_, err := writer.Write(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = writer.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body = buffer.Bytes()
+	}
+
+	return body
+}
+
+func TestDecompress(t *testing.T) {
+	text := "<a>1</a>"
+	tests := []struct {
+		name            string
+		body            []byte
+		contentEncoding string
+	}{
+		{"identity", []byte(text), ""},
+		{"gzip", compress(t, text, "gzip"), "gzip"},
+		{"x-gzip", compress(t, text, "gzip"), "X-Gzip"},
+		{"deflate", compress(t, text, "deflate"), "deflate"},
+		// some servers send deflate without the zlib wrapper
+		{"raw deflate", compress(t, text, "raw deflate"), "deflate"},
+		{"br", compress(t, text, "br"), "br"},
+		{"gzip then br", compress(t, text, "gzip", "br"), "gzip, br"},
+	}
+
+	for _, test := range tests {
+		reader, err := decompress(bytes.NewReader(test.body), test.contentEncoding)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		body, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+
+		err = reader.Close()
+		if err != nil {
+			t.Errorf("%s: close: %v", test.name, err)
+		}
+
+		if string(body) != text {
+			t.Errorf("%s: got %q, expect %q", test.name, body, text)
+		}
+	}
+
+	for _, contentEncoding := range []string{"compress", "gzip, zstd"} {
+		_, err := decompress(bytes.NewReader(compress(t, text, "gzip")), contentEncoding)
+		if err == nil {
+			t.Errorf("%s: got no error for unsupported encoding", contentEncoding)
+		}
+	}
+
+	_, err := decompress(bytes.NewReader([]byte(text)), "gzip")
+	if err == nil {
+		t.Error("gzip header of plain body: got no error")
+	}
+}
+
+func TestDecodeCharset(t *testing.T) {
+	// 中文 in gbk, 日本 in shift_jis, café in windows-1252
+	gbk, shiftJIS, latin := "\xd6\xd0\xce\xc4", "\x93\xfa\x96\x7b", "caf\xe9"
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		label       string
+		expect      string
+		charset     string
+	}{
+		{"utf-8", "中文", "text/html; charset=utf-8", "", "中文", "utf-8"},
+		{"utf-8 byte order mark", "\xef\xbb\xbf中文", "text/html", "", "中文", "utf-8"},
+		{"gbk header", gbk, "text/html; charset=gbk", "", "中文", "gbk"},
+		{"gbk meta", `<meta charset="gbk">` + gbk, "text/html", "", `<meta charset="gbk">中文`, "gbk"},
+		{"gbk option", gbk, "text/html", "gbk", "中文", "gbk"},
+		// the option overrides a wrong header
+		{"gbk option over header", gbk, "text/html; charset=utf-8", "gb2312", "中文", "gbk"},
+		{"shift_jis header", shiftJIS, "text/plain; charset=Shift_JIS", "", "日本", "shift_jis"},
+		{"shift_jis option", shiftJIS, "", "shift_jis", "日本", "shift_jis"},
+		// invalid utf-8 without any hint is read as windows-1252
+		{"windows-1252 fallback", latin, "text/html", "", "café", "windows-1252"},
+		{"raw", gbk, "text/html; charset=gbk", charsetRaw, gbk, ""},
+		{"binary", gbk, "image/png", "", gbk, ""},
+	}
+
+	for _, test := range tests {
+		body, name, err := decodeCharset([]byte(test.body), test.contentType, test.label)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if string(body) != test.expect || name != test.charset {
+			t.Errorf("%s: got %q from %q, expect %q from %q", test.name, body, name, test.expect, test.charset)
+		}
+	}
+
+	_, _, err := decodeCharset([]byte(gbk), "text/html", "klingon")
+	if err == nil {
+		t.Error("unknown charset: got no error")
+	}
+}
@@ -1,18 +1,61 @@
 package jobs
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 )
 
+// ErrCommandTimeout command killed after its timeout
+var ErrCommandTimeout = errors.New("command timed out")
+
+// defaultOutputTail lines of output kept for the error of a failed command
+const defaultOutputTail = 20
+
+// stdout formats of execute
+const (
+	stdoutText = "text"
+	stdoutJSON = "json"
+)
+
 // Execute execute external command
+//
+// output captured into context is not printed, commands exiting with one of
+// else exit codes run the else jobs instead of failing, so do output not
+// matching regexp. output not captured goes to log files if set, otherwise
+// to the terminal, lines prefixed to tell parallel branches apart
 type Execute struct {
-	command string
-	args    []string
-	dir     string
-	debug   bool
+	command       string
+	args          []string
+	dir           string
+	env           map[string]string
+	stdin         string
+	timeout       time.Duration
+	stdoutSet     string
+	stdoutFormat  string
+	stderrSet     string
+	regexp        *regexp.Regexp
+	sets          []string
+	exitCodeSet   string
+	elseExitCodes map[int]bool
+	prefix        string
+	stdoutFile    string
+	stderrFile    string
+	outputTail    int
+	debug         bool
+	retrying
 }
 
 // newExecute create execute action
@@ -29,62 +72,412 @@ func newExecute(c *Config) (interface{}, error) {
 
 	dir := c.StringDefault("dir", "")
 
+	stdoutFormat := c.StringDefault("stdout_format", stdoutText)
+	if stdoutFormat != stdoutText && stdoutFormat != stdoutJSON {
+		return nil, fmt.Errorf("invalid stdout format [%s], expect %s or %s", stdoutFormat, stdoutText, stdoutJSON)
+	}
+
+	var regex *regexp.Regexp
+	var sets []string
+	if expression := c.StringDefault("regexp", ""); expression != "" {
+		regex, err = regexp.Compile(expression)
+		if err != nil {
+			zap.L().Error("compile regex expression failed",
+				zap.Error(err),
+				zap.String("expression", expression))
+			return nil, err
+		}
+
+		sets, err = c.Strings("sets")
+		if err != nil {
+			return nil, err
+		}
+
+		if regex.NumSubexp() != len(sets) {
+			return nil, ErrKeyCountInvalid
+		}
+	}
+
+	elseExitCodes := make(map[int]bool)
+	if _, found := (*c)["else_exit_codes"]; found {
+		codes, err := c.Ints("else_exit_codes")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, code := range codes {
+			elseExitCodes[code] = true
+		}
+	}
+
 	debug := c.BoolDefault("debug", false)
 
+	retrying, err := newRetrying(c)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Execute{
-		command: command,
-		args:    args,
-		dir:     dir,
-		debug:   debug,
+		command:       command,
+		args:          args,
+		dir:           dir,
+		env:           c.MapDefault("env"),
+		stdin:         c.StringDefault("stdin", ""),
+		timeout:       c.DurationDefault("timeout", 0),
+		stdoutSet:     c.StringDefault("stdout_set", ""),
+		stdoutFormat:  stdoutFormat,
+		stderrSet:     c.StringDefault("stderr_set", ""),
+		regexp:        regex,
+		sets:          sets,
+		exitCodeSet:   c.StringDefault("exit_code_set", ""),
+		elseExitCodes: elseExitCodes,
+		prefix:        c.StringDefault("prefix", ""),
+		stdoutFile:    c.StringDefault("stdout_file", ""),
+		stderrFile:    c.StringDefault("stderr_file", ""),
+		outputTail:    c.IntDefault("output_tail", defaultOutputTail),
+		debug:         debug,
+		retrying:      retrying,
 	}, nil
 }
 
+// Plan describe command in plan mode
+func (s Execute) Plan(ctx *Context) (string, error) {
+	args := make([]string, len(s.args))
+	for index, arg := range s.args {
+		value, err := ctx.Expand(arg)
+		if err != nil {
+			return "", err
+		}
+
+		args[index] = value
+	}
+
+	dir, err := ctx.Expand(s.dir)
+	if err != nil {
+		return "", err
+	}
+
+	if dir == "" {
+		return shellQuote(s.command, args), nil
+	}
+
+	return fmt.Sprintf("%s (dir: %s)", shellQuote(s.command, args), dir), nil
+}
+
+// Attributes describe expanded options for tracing
+func (s Execute) Attributes(ctx *Context) map[string]string {
+	args := make([]string, len(s.args))
+	for index, arg := range s.args {
+		args[index] = expandAttribute(ctx, arg)
+	}
+
+	return map[string]string{
+		"command": shellQuote(s.command, args),
+		"dir":     expandAttribute(ctx, s.dir),
+	}
+}
+
 // Do do job
-func (s Execute) Do(ctx *Context) error {
+func (s Execute) Do(ctx *Context) (bool, error) {
+	var err error
 	args := make([]string, len(s.args))
 	for index, arg := range s.args {
-		args[index] = ctx.Expand(arg)
+		args[index], err = ctx.Expand(arg)
+		if err != nil {
+			return false, err
+		}
 	}
 
-	dir := ctx.Expand(s.dir)
+	dir, err := ctx.Expand(s.dir)
+	if err != nil {
+		return false, err
+	}
+
+	env, err := s.environ(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	stdin, err := ctx.Expand(s.stdin)
+	if err != nil {
+		return false, err
+	}
 
 	if s.debug {
-		zap.L().Debug("execute command",
+		ctx.L().Debug("execute command",
 			zap.String("command", s.command),
 			zap.Strings("args", args),
 			zap.String("dir", dir))
 	}
 
-	cmd := exec.Command(s.command, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Dir = dir
+	prefix, err := ctx.Expand(s.prefix)
+	if err != nil {
+		return false, err
+	}
+
+	stdoutFile, stderrFile, closeFiles, err := s.openFiles(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer closeFiles()
+
+	var stdout, stderr *bytes.Buffer
+	if s.stdoutSet != "" || s.regexp != nil {
+		stdout = new(bytes.Buffer)
+	}
+	if s.stderrSet != "" {
+		stderr = new(bytes.Buffer)
+	}
+
+	var tail *outputTail
+	if s.outputTail > 0 {
+		tail = newOutputTail(s.outputTail)
+	}
+
+	// only exit codes listed in retry_exit_codes are retried
+	err = s.retry.run(ctx, "execute", func() error {
+		done, cancel := ctx.done(), context.CancelFunc(func() {})
+		if s.timeout > 0 {
+			done, cancel = context.WithTimeout(done, s.timeout)
+		}
+		defer cancel()
+
+		cmd := exec.CommandContext(done, s.command, args...)
+		cmd.Dir = dir
+		cmd.Env = env
+		if s.stdin != "" {
+			cmd.Stdin = strings.NewReader(stdin)
+		}
+
+		// the tail tells about the last attempt only
+		if tail != nil {
+			tail.Reset()
+		}
+
+		var flushStdout, flushStderr func() error
+		cmd.Stdout, flushStdout = outputWriter(os.Stdout, stdoutFile, stdout, prefix, tail)
+		cmd.Stderr, flushStderr = outputWriter(os.Stderr, stderrFile, stderr, prefix, tail)
+
+		err := cmd.Run()
+		flushStdout()
+		flushStderr()
+
+		if err != nil && done.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			return fmt.Errorf("%w after %s", ErrCommandTimeout, s.timeout)
+		}
+
+		return err
+	}, nil, zap.String("command", s.command), zap.Strings("args", args))
 
-	err := cmd.Run()
+	exitCode := 0
 	if err != nil {
-		ee, ok := err.(*exec.ExitError)
-		if !ok {
-			zap.L().Error("execute command failed",
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			exitCode = ee.ExitCode()
+		}
+
+		if ee == nil || !s.elseExitCodes[exitCode] {
+			if tail != nil {
+				err = &CommandError{Err: err, Output: tail.String()}
+			}
+
+			ctx.L().Error("execute command failed",
 				zap.Error(err),
 				zap.String("command", s.command),
 				zap.Strings("args", args))
-			return err
+			return false, err
 		}
+	}
+
+	if s.exitCodeSet != "" {
+		ctx.SetValue(s.exitCodeSet, exitCode)
+	}
+
+	if stderr != nil {
+		ctx.Set(s.stderrSet, strings.TrimRight(stderr.String(), "\r\n"))
+	}
 
-		zap.L().Error("execute command failed",
-			zap.Error(ee),
-			zap.ByteString("stderr", ee.Stderr),
+	if exitCode != 0 {
+		ctx.L().Info("command exited with else exit code, run else jobs",
+			zap.Int("exitCode", exitCode),
 			zap.String("command", s.command),
 			zap.Strings("args", args))
-		return err
+		return false, nil
+	}
+
+	var output []byte
+	if stdout != nil {
+		output = stdout.Bytes()
+	}
+
+	matched, err := s.capture(ctx, output)
+	if err != nil {
+		return false, err
 	}
 
 	if s.debug {
-		zap.L().Debug("execute command success",
+		ctx.L().Debug("execute command success",
 			zap.String("command", s.command),
 			zap.Strings("args", args),
 			zap.String("dir", dir))
 	}
 
-	return nil
+	return matched, nil
+}
+
+// outputWriter get writer of one output stream of a command attempt and a
+// function flushing it: the capture buffer if set, the log file if set, the
+// terminal with prefixed lines otherwise, and the tail if set. the capture
+// buffer is emptied for the attempt
+func outputWriter(terminal io.Writer, file io.Writer, capture *bytes.Buffer, prefix string, tail *outputTail) (io.Writer, func() error) {
+	flush := func() error { return nil }
+
+	var writers []io.Writer
+	if capture != nil {
+		capture.Reset()
+		writers = append(writers, capture)
+	}
+
+	if file != nil {
+		writers = append(writers, file)
+	}
+
+	if capture == nil && file == nil {
+		if prefix != "" {
+			writer := &prefixWriter{out: terminal, prefix: prefix}
+			terminal, flush = writer, writer.Flush
+		}
+		writers = append(writers, terminal)
+	}
+
+	if tail != nil {
+		writers = append(writers, tail)
+	}
+
+	if len(writers) == 1 {
+		return writers[0], flush
+	}
+
+	return io.MultiWriter(writers...), flush
+}
+
+// openFiles open log files of stdout and stderr, expanded per branch, nil if not set
+func (s Execute) openFiles(ctx *Context) (io.Writer, io.Writer, func(), error) {
+	var files []*os.File
+	closeFiles := func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+
+	opened := make(map[string]*os.File)
+	open := func(expression string) (io.Writer, error) {
+		if expression == "" {
+			return nil, nil
+		}
+
+		path, err := ctx.Expand(expression)
+		if err != nil {
+			return nil, err
+		}
+
+		// both streams may go to the same file
+		if file, found := opened[path]; found {
+			return file, nil
+		}
+
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return nil, err
+		}
+
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			ctx.L().Error("open command log file failed", zap.Error(err), zap.String("path", path))
+			return nil, err
+		}
+
+		opened[path] = file
+		files = append(files, file)
+		return file, nil
+	}
+
+	stdout, err := open(s.stdoutFile)
+	if err != nil {
+		closeFiles()
+		return nil, nil, nil, err
+	}
+
+	stderr, err := open(s.stderrFile)
+	if err != nil {
+		closeFiles()
+		return nil, nil, nil, err
+	}
+
+	return stdout, stderr, closeFiles, nil
+}
+
+// environ get environment of command: the environment of crawl and env expanded from context
+func (s Execute) environ(ctx *Context) ([]string, error) {
+	if len(s.env) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(s.env))
+	for key := range s.env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	env := os.Environ()
+	for _, key := range keys {
+		value, err := ctx.Expand(s.env[key])
+		if err != nil {
+			return nil, err
+		}
+
+		env = append(env, key+"="+value)
+	}
+
+	return env, nil
+}
+
+// capture set captured stdout on context, false when regexp does not match
+func (s Execute) capture(ctx *Context, stdout []byte) (bool, error) {
+	if s.stdoutSet != "" {
+		switch s.stdoutFormat {
+		case stdoutJSON:
+			var value interface{}
+			err := json.Unmarshal(stdout, &value)
+			if err != nil {
+				ctx.L().Error("parse command stdout as json failed",
+					zap.Error(err),
+					zap.String("command", s.command),
+					zap.ByteString("stdout", stdout))
+				return false, fmt.Errorf("parse stdout of %s as json failed: %w", s.command, err)
+			}
+
+			ctx.SetValue(s.stdoutSet, value)
+		default:
+			ctx.Set(s.stdoutSet, strings.TrimRight(string(stdout), "\r\n"))
+		}
+	}
+
+	if s.regexp == nil {
+		return true, nil
+	}
+
+	groups := s.regexp.FindSubmatch(stdout)
+	if groups == nil {
+		ctx.L().Info("command stdout does not match regexp, run else jobs",
+			zap.String("command", s.command),
+			zap.String("expression", s.regexp.String()))
+		return false, nil
+	}
+
+	for index, key := range s.sets {
+		ctx.SetValue(key, capturedValue(string(groups[index+1])))
+	}
+
+	return true, nil
 }
@@ -0,0 +1,207 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestExecute create execute action running script with sh, without waits between retries
+func newTestExecute(t *testing.T, script string, options Config) *Execute {
+	config := Config{"command": "sh", "args": []interface{}{"-c", script}, "retry": int64(0), "interval": "1ms", "jitter": 0.0}
+	for key, value := range options {
+		config[key] = value
+	}
+
+	action, err := newExecute(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return action.(*Execute)
+}
+
+func TestExecuteExitCodes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "execute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// count attempts in a file of the working directory, fail with code until the last attempt,
+	// $$ keeps the shell variables from being expanded from context
+	attempts := func(code, succeedAt int) string {
+		return fmt.Sprintf(`n=$$(($$(cat attempts 2>/dev/null || echo 0) + 1)); echo $$n > attempts; [ $$n -ge %d ] || exit %d`, succeedAt, code)
+	}
+
+	tests := []struct {
+		name     string
+		script   string
+		options  Config
+		matched  bool
+		exitCode int
+		fail     bool
+		attempts int
+	}{
+		{"success", "exit 0", nil, true, 0, false, 0},
+		{"failure", "exit 4", nil, false, 4, true, 0},
+		{"else exit code", "exit 3", Config{"else_exit_codes": []interface{}{int64(3)}}, false, 3, false, 0},
+		{"other than else exit code", "exit 4", Config{"else_exit_codes": []interface{}{int64(3)}}, false, 4, true, 0},
+		{"retry exit code", attempts(75, 3), Config{"retry": int64(2), "retry_exit_codes": []interface{}{int64(75)}}, true, 0, false, 3},
+		{"retries run out", attempts(75, 9), Config{"retry": int64(2), "retry_exit_codes": []interface{}{int64(75)}}, false, 75, true, 3},
+		// exit codes not listed are not retried
+		{"not retry exit code", attempts(1, 3), Config{"retry": int64(2), "retry_exit_codes": []interface{}{int64(75)}}, false, 1, true, 1},
+	}
+
+	for _, test := range tests {
+		os.Remove(filepath.Join(dir, "attempts"))
+
+		options := Config{"dir": dir, "exit_code_set": "code"}
+		for key, value := range test.options {
+			options[key] = value
+		}
+
+		ctx := NewContext()
+		matched, err := newTestExecute(t, test.script, options).Do(ctx)
+		if fail := err != nil; fail != test.fail || matched != test.matched {
+			t.Errorf("%s: got matched %v and error %v, expect matched %v and failure %v", test.name, matched, err, test.matched, test.fail)
+		}
+
+		var exitError *exec.ExitError
+		if test.fail && (!errors.As(err, &exitError) || exitError.ExitCode() != test.exitCode) {
+			t.Errorf("%s: got error %v, expect exit code %d", test.name, err, test.exitCode)
+		}
+
+		if code, found := ctx.Get("code"); !test.fail && (!found || code != test.exitCode) {
+			t.Errorf("%s: got exit code %v in context, expect %d", test.name, code, test.exitCode)
+		}
+
+		if test.attempts > 0 {
+			count, _ := ioutil.ReadFile(filepath.Join(dir, "attempts"))
+			if got := strings.TrimSpace(string(count)); got != fmt.Sprint(test.attempts) {
+				t.Errorf("%s: got %s attempts, expect %d", test.name, got, test.attempts)
+			}
+		}
+	}
+}
+
+func TestExecuteCaptures(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		options Config
+		matched bool
+		expect  map[string]interface{}
+	}{
+		{"stdout", `echo "  two lines"; echo`, Config{"stdout_set": "out"}, true, map[string]interface{}{"out": "  two lines"}},
+		{"stdout json", `echo '{"id": 42, "tags": ["a"]}'`, Config{"stdout_set": "out", "stdout_format": stdoutJSON}, true,
+			map[string]interface{}{"out": map[string]interface{}{"id": 42.0, "tags": []interface{}{"a"}}}},
+		{"stderr", `echo out; echo err >&2`, Config{"stdout_set": "out", "stderr_set": "err"}, true, map[string]interface{}{"out": "out", "err": "err"}},
+		// numbers become typed values, the rest stays text
+		{"regexp", `echo "id=42 price=9.5 name=007"`, Config{"regexp": `id=(\d+) price=([\d.]+) name=(\w+)`, "sets": []interface{}{"id", "price", "name"}}, true,
+			map[string]interface{}{"id": 42, "price": 9.5, "name": "007"}},
+		{"regexp not matching", `echo "nothing"`, Config{"regexp": `id=(\d+)`, "sets": []interface{}{"id"}}, false, nil},
+	}
+
+	for _, test := range tests {
+		ctx := NewContext()
+		matched, err := newTestExecute(t, test.script, test.options).Do(ctx)
+		if err != nil || matched != test.matched {
+			t.Errorf("%s: got matched %v and error %v, expect matched %v", test.name, matched, err, test.matched)
+			continue
+		}
+
+		for key, expect := range test.expect {
+			value, _ := ctx.Get(key)
+			if !reflect.DeepEqual(normalizeValue(value), normalizeValue(expect)) {
+				t.Errorf("%s: got %s %#v, expect %#v", test.name, key, value, expect)
+			}
+		}
+	}
+
+	// captured text expands back to itself
+	ctx := NewContext()
+	_, err := newTestExecute(t, `echo "id=042"`, Config{"regexp": `id=(\d+)`, "sets": []interface{}{"id"}}).Do(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, _ := ctx.String("id"); id != "042" {
+		t.Errorf("got id %s, expect 042", id)
+	}
+}
+
+func TestExecuteOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "execute")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// stdout and stderr of every branch go to its own file, created with its directory
+	ctx := NewContext()
+	ctx.Set("page", "1")
+	file := filepath.Join(dir, "logs", "${page}.log")
+	action := newTestExecute(t, "echo out; echo err >&2", Config{"stdout_file": file, "stderr_file": file})
+	for attempt := 0; attempt < 2; attempt++ {
+		_, err = action.Do(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	log, err := ioutil.ReadFile(filepath.Join(dir, "logs", "1.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// appended on every run
+	if lines := strings.Fields(string(log)); len(lines) != 4 || strings.Count(string(log), "out") != 2 || strings.Count(string(log), "err") != 2 {
+		t.Errorf("got log %q, expect out and err of both runs", log)
+	}
+
+	// output not captured goes to the terminal, every line prefixed
+	terminal, err := ioutil.TempFile(dir, "terminal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer terminal.Close()
+
+	stdout := os.Stdout
+	os.Stdout = terminal
+	_, err = newTestExecute(t, `printf "a\nb"`, Config{"prefix": "[${page}] "}).Do(ctx)
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	printed, err := ioutil.ReadFile(terminal.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(printed) != "[1] a\n[1] b\n" {
+		t.Errorf("got terminal output %q, expect prefixed lines", printed)
+	}
+
+	// the error of a failed command ends with the last lines of its output
+	script := `for i in 1 2 3 4 5 6; do echo line $$i; done; exit 2`
+	tail := Config{"stdout_file": filepath.Join(dir, "tail.log"), "stderr_file": filepath.Join(dir, "tail.log"), "output_tail": int64(3)}
+	_, err = newTestExecute(t, script, tail).Do(ctx)
+	var commandError *CommandError
+	if !errors.As(err, &commandError) || commandError.Output != "line 4\nline 5\nline 6" {
+		t.Errorf("got error %v, expect the last 3 lines of output", err)
+	}
+
+	tail["output_tail"] = int64(0)
+	_, err = newTestExecute(t, script, tail).Do(ctx)
+	if err == nil || errors.As(err, &commandError) {
+		t.Errorf("output tail 0: got error %v, expect the exit error without output", err)
+	}
+}
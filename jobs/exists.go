@@ -34,10 +34,19 @@ func newExists(c *Config) (interface{}, error) {
 	}, nil
 }
 
+// Attributes describe expanded options for tracing
+func (s Exists) Attributes(ctx *Context) map[string]string {
+	return map[string]string{"path": expandAttribute(ctx, s.path)}
+}
+
 // Do do job
 func (s Exists) Do(ctx *Context) (bool, error) {
-	path := ctx.Expand(s.path)
-	_, err := os.Stat(path)
+	path, err := ctx.Expand(s.path)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(path)
 	exists := err == nil
 
 	_continue := exists
@@ -51,7 +60,7 @@ func (s Exists) Do(ctx *Context) (bool, error) {
 			status = "file not exists"
 		}
 
-		zap.L().Debug(status,
+		ctx.L().Debug(status,
 			zap.String("path", path),
 			zap.Bool("exists", err == nil),
 			zap.Bool("continue", _continue))
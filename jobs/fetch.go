@@ -2,12 +2,10 @@ package jobs
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
-	"time"
+	"strings"
 
-	"github.com/nzai/crawl/constants"
-
-	"github.com/nzai/netop"
 	"go.uber.org/zap"
 )
 
@@ -16,15 +14,31 @@ var (
 	ErrKeyCountInvalid = errors.New("match group count different from context key count")
 )
 
+// what fetch does with responses rejected by its guards
+const (
+	invalidRetry = "retry"
+	invalidElse  = "else"
+	invalidFail  = "fail"
+)
+
 // Fetch http get html and match regexp
 type Fetch struct {
-	url           string
-	headers       map[string]string
-	retry         int
-	retryInterval time.Duration
-	regexp        *regexp.Regexp
-	sets          []string
-	debug         bool
+	url        string
+	headers    map[string]string
+	response   responseOptions
+	invalid    string
+	proxy      string
+	proxies    *proxyPool
+	client     string
+	httpClient *httpClient
+	regexp     *regexp.Regexp
+	sets       []string
+	statusSet  string
+	urlSet     string
+	parallel   int
+	debug      bool
+	ordering
+	retrying
 }
 
 // newFetch create fetch action
@@ -36,8 +50,10 @@ func newFetch(c *Config) (interface{}, error) {
 
 	headers := c.MapDefault("headers")
 
-	retry := c.IntDefault("retry", constants.DefaultRetry)
-	retryInterval := c.DurationDefault("interval", constants.DefaultRetryInterval)
+	retrying, err := newRetrying(c)
+	if err != nil {
+		return nil, err
+	}
 
 	expression, err := c.String("regexp")
 	if err != nil {
@@ -57,78 +73,219 @@ func newFetch(c *Config) (interface{}, error) {
 		return nil, err
 	}
 
+	options, err := newResponseOptions(c)
+	if err != nil {
+		return nil, err
+	}
+
+	invalid := c.StringDefault("invalid", invalidRetry)
+	switch invalid {
+	case invalidRetry:
+		options.retryInvalid = true
+	case invalidElse, invalidFail:
+	default:
+		return nil, fmt.Errorf("invalid value [%s] of invalid, expect retry, else or fail", invalid)
+	}
+
+	parallel := c.IntDefault("parallel", 0)
+
+	ordering, err := newOrdering(c)
+	if err != nil {
+		return nil, err
+	}
+
 	debug := c.BoolDefault("debug", false)
 
 	return &Fetch{
-		url:           url,
-		headers:       headers,
-		retry:         retry,
-		retryInterval: retryInterval,
-		regexp:        regex,
-		sets:          sets,
-		debug:         debug,
+		url:       url,
+		headers:   headers,
+		response:  options,
+		invalid:   invalid,
+		proxy:     c.StringDefault("proxy", ""),
+		client:    c.StringDefault("client", ""),
+		regexp:    regex,
+		sets:      sets,
+		statusSet: c.StringDefault("status_set", ""),
+		urlSet:    c.StringDefault("url_set", ""),
+		parallel:  parallel,
+		debug:     debug,
+		ordering:  ordering,
+		retrying:  retrying,
 	}, nil
 }
 
+// newResponseOptions read status, content type, size, body pattern and charset options
+func newResponseOptions(c *Config) (responseOptions, error) {
+	var options responseOptions
+	var err error
+
+	if _, found := (*c)["status"]; found {
+		options.status, err = c.Strings("status")
+		if err != nil {
+			return options, err
+		}
+	}
+
+	if _, found := (*c)["content_types"]; found {
+		options.contentTypes, err = c.Strings("content_types")
+		if err != nil {
+			return options, err
+		}
+	}
+
+	options.maxSize = int64(c.IntDefault("max_size", 0))
+	options.charset = strings.ToLower(c.StringDefault("charset", ""))
+	if !validCharset(options.charset) {
+		return options, fmt.Errorf("unknown charset: %s", options.charset)
+	}
+
+	for key, target := range map[string]**regexp.Regexp{"must_match": &options.mustMatch, "must_not_match": &options.mustNotMatch} {
+		expression := c.StringDefault(key, "")
+		if expression == "" {
+			continue
+		}
+
+		*target, err = regexp.Compile(expression)
+		if err != nil {
+			return options, fmt.Errorf("compile %s failed: %w", key, err)
+		}
+	}
+
+	return options, nil
+}
+
+// useResources resolve proxy, client and retry options to resources of the job file
+func (s *Fetch) useResources(r *resources) error {
+	pool, err := proxyPoolOf(s.proxy, r.proxies)
+	if err != nil {
+		return err
+	}
+
+	client, err := httpClientOf(s.client, r.clients)
+	if err != nil {
+		return err
+	}
+
+	s.proxies = pool
+	s.httpClient = client
+	return s.retrying.useResources(r)
+}
+
+// Parallel get max concurrent branches
+func (s Fetch) Parallel() int {
+	return s.parallel
+}
+
+// Plan describe request in plan mode
+func (s Fetch) Plan(ctx *Context) (string, error) {
+	url, err := ctx.Expand(s.url)
+	if err != nil {
+		return "", err
+	}
+
+	return "GET " + url, nil
+}
+
+// Attributes describe expanded options for tracing
+func (s Fetch) Attributes(ctx *Context) map[string]string {
+	return map[string]string{"url": expandAttribute(ctx, s.url)}
+}
+
 // Do do job
 func (s Fetch) Do(ctx *Context) ([]*Context, error) {
-	html, err := s.getHTML(ctx)
+	response, err := s.get(ctx)
+	if err != nil {
+		var invalid *InvalidResponseError
+		if errors.As(err, &invalid) && s.invalid == invalidElse {
+			ctx.L().Warn("response rejected, run else jobs", zap.Error(err))
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	ctxs, err := s.match(ctx, string(response.Body))
 	if err != nil {
 		return nil, err
 	}
 
-	return s.match(ctx, html)
+	for _, c := range ctxs {
+		if s.statusSet != "" {
+			c.SetValue(s.statusSet, response.StatusCode)
+		}
+
+		if s.urlSet != "" {
+			c.Set(s.urlSet, response.URL)
+		}
+	}
+
+	return ctxs, nil
 }
 
-func (s Fetch) getHTML(ctx *Context) (string, error) {
-	url := ctx.Expand(s.url)
-	parameters := []netop.RequestParam{netop.Retry(s.retry, s.retryInterval)}
+func (s Fetch) get(ctx *Context) (*httpResponse, error) {
+	url, err := ctx.Expand(s.url)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string]string, len(s.headers))
 	for key, value := range s.headers {
-		parameters = append(parameters, netop.Header(key, ctx.Expand(value)))
+		header, err := ctx.Expand(value)
+		if err != nil {
+			return nil, err
+		}
+
+		headers[key] = header
 	}
 
-	html, err := netop.GetString(url, parameters...)
+	response, err := httpGet(ctx, "fetch", url, headers, s.response, s.httpClient, s.proxies, s.retry)
 	if err != nil {
-		zap.L().Error("get html string failed",
+		ctx.L().Error("get html string failed",
 			zap.Error(err),
 			zap.String("url", url),
 			zap.Any("headers", s.headers))
-		return "", err
+		return nil, err
 	}
 
 	if s.debug {
-		zap.L().Debug("get html success",
+		ctx.L().Debug("get html success",
 			zap.String("url", url),
-			zap.Any("headers", s.headers))
+			zap.Any("headers", s.headers),
+			zap.String("charset", response.Charset))
 	}
 
-	return html, nil
+	return response, nil
 }
 
 func (s Fetch) match(ctx *Context, html string) ([]*Context, error) {
-	groups := s.regexp.FindAllStringSubmatch(html, -1)
-	if s.debug {
-		zap.L().Debug("match html success",
-			zap.String("expression", s.regexp.String()),
+	return matchHTML(ctx, s.regexp, s.sets, html, s.debug)
+}
+
+// matchHTML clone context for every match of expression in html, setting its groups as sets
+func matchHTML(ctx *Context, expression *regexp.Regexp, sets []string, html string, debug bool) ([]*Context, error) {
+	groups := expression.FindAllStringSubmatch(html, -1)
+	if debug {
+		ctx.L().Debug("match html success",
+			zap.String("expression", expression.String()),
 			zap.Int("matches", len(groups)))
 
 		if len(groups) == 0 {
-			zap.L().Debug("find 0 match", zap.String("html", html))
+			ctx.L().Debug("find 0 match", zap.String("html", html))
 		}
 	}
 
 	ctxs := make([]*Context, len(groups))
 	for index, group := range groups {
-		if len(s.sets) != len(group)-1 {
+		if len(sets) != len(group)-1 {
 			return nil, ErrKeyCountInvalid
 		}
 
 		cloneCtx := ctx.Clone()
-		for keyIndex, key := range s.sets {
-			cloneCtx.Set(key, group[keyIndex+1])
+		for keyIndex, key := range sets {
+			cloneCtx.SetValue(key, capturedValue(group[keyIndex+1]))
 
-			if s.debug {
-				zap.L().Debug("set match context success",
+			if debug {
+				ctx.L().Debug("set match context success",
 					zap.String("key", key),
 					zap.String("value", group[keyIndex+1]))
 			}
@@ -0,0 +1,319 @@
+package jobs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// job file formats
+const (
+	formatToml = "toml"
+	formatYaml = "yaml"
+	formatJSON = "json"
+)
+
+// fileFormat get job file format by extension, toml by default
+func fileFormat(filePath string) string {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".yaml", ".yml":
+		return formatYaml
+	case ".json":
+		return formatJSON
+	default:
+		return formatToml
+	}
+}
+
+// decodeFile decode job file into config by its extension
+//
+// yaml and json values are normalized to the types the toml decoder produces:
+// integers are int64 and arrays of tables are []map[string]interface{}
+func decodeFile(filePath string) (Config, error) {
+	format := fileFormat(filePath)
+	if format == formatToml {
+		c := make(Config)
+		_, err := toml.DecodeFile(filePath, &c)
+		if err != nil {
+			return nil, err
+		}
+
+		return c, nil
+	}
+
+	buffer, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if format == formatYaml {
+		err = yaml.Unmarshal(buffer, &value)
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(buffer))
+		decoder.UseNumber()
+		err = decoder.Decode(&value)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	object, ok := normalizeConfig(value).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("job file %s must contain a table of jobs", filePath)
+	}
+
+	return Config(object), nil
+}
+
+// normalizeConfig convert decoded yaml or json value to toml value types
+func normalizeConfig(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = normalizeConfig(item)
+		}
+		return m
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normalizeConfig(item)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		tables := make([]map[string]interface{}, 0, len(v))
+		for index, item := range v {
+			list[index] = normalizeConfig(item)
+			if table, ok := list[index].(map[string]interface{}); ok {
+				tables = append(tables, table)
+			}
+		}
+
+		if len(v) > 0 && len(tables) == len(v) {
+			return tables
+		}
+		return list
+	case int:
+		return int64(v)
+	case uint64:
+		return int64(v)
+	case json.Number:
+		if intValue, err := v.Int64(); err == nil {
+			return intValue
+		}
+
+		floatValue, _ := v.Float64()
+		return floatValue
+	default:
+		return v
+	}
+}
+
+// encodeConfig encode config in format
+func encodeConfig(writer io.Writer, c Config, format string) error {
+	switch format {
+	case formatYaml:
+		encoder := yaml.NewEncoder(writer)
+		encoder.SetIndent(2)
+		err := encoder.Encode(map[string]interface{}(c))
+		if err != nil {
+			return err
+		}
+
+		return encoder.Close()
+	case formatJSON:
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		return encoder.Encode(c)
+	default:
+		return toml.NewEncoder(writer).Encode(map[string]interface{}(c))
+	}
+}
+
+// ConvertFile convert job file to another format chosen by the target extension
+func ConvertFile(sourcePath, targetPath string) error {
+	c, err := decodeFile(sourcePath)
+	if err != nil {
+		zap.L().Error("decode job file failed", zap.Error(err), zap.String("path", sourcePath))
+		return err
+	}
+
+	buffer := new(bytes.Buffer)
+	err = encodeConfig(buffer, c, fileFormat(targetPath))
+	if err != nil {
+		zap.L().Error("encode job file failed", zap.Error(err), zap.String("path", targetPath))
+		return err
+	}
+
+	err = ioutil.WriteFile(targetPath, buffer.Bytes(), 0644)
+	if err != nil {
+		zap.L().Error("write job file failed", zap.Error(err), zap.String("path", targetPath))
+		return err
+	}
+
+	return nil
+}
+
+// locateLines map table and key paths of job file to their line numbers
+func locateLines(filePath string) (map[string]int, error) {
+	switch fileFormat(filePath) {
+	case formatYaml:
+		return locateYamlLines(filePath)
+	case formatJSON:
+		return locateJSONLines(filePath)
+	default:
+		return locateTomlLines(filePath)
+	}
+}
+
+// locateYamlLines map paths of yaml file to line numbers
+func locateYamlLines(filePath string) (map[string]int, error) {
+	buffer, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var root yaml.Node
+	err = yaml.Unmarshal(buffer, &root)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make(map[string]int)
+	var walk func(path string, node *yaml.Node)
+	walk = func(path string, node *yaml.Node) {
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				walk(path, child)
+			}
+		case yaml.MappingNode:
+			for index := 0; index+1 < len(node.Content); index += 2 {
+				keyPath := joinPath(path, node.Content[index].Value)
+				lines[keyPath] = node.Content[index].Line
+				walk(keyPath, node.Content[index+1])
+			}
+		case yaml.SequenceNode:
+			for index, child := range node.Content {
+				itemPath := fmt.Sprintf("%s[%d]", path, index)
+				lines[itemPath] = child.Line
+				walk(itemPath, child)
+			}
+		}
+	}
+	walk("", &root)
+
+	return lines, nil
+}
+
+// locateJSONLines map paths of json file to line numbers
+func locateJSONLines(filePath string) (map[string]int, error) {
+	buffer, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// lineAt get line of the token after offset, skipping separators before it
+	lineAt := func(offset int64) int {
+		for offset < int64(len(buffer)) && strings.IndexByte(" \t\r\n,:", buffer[offset]) >= 0 {
+			offset++
+		}
+
+		return bytes.Count(buffer[:offset], []byte("\n")) + 1
+	}
+
+	lines := make(map[string]int)
+	reader := bytes.NewReader(buffer)
+	decoder := json.NewDecoder(reader)
+
+	// offset of the next token: bytes read from the buffer less those the
+	// decoder holds unread, Decoder.InputOffset needs go 1.14
+	inputOffset := func() int64 {
+		unread := decoder.Buffered()
+		if r, ok := unread.(interface{ Len() int }); ok {
+			return int64(len(buffer) - reader.Len() - r.Len())
+		}
+
+		n, _ := io.Copy(ioutil.Discard, unread)
+		return int64(len(buffer)-reader.Len()) - n
+	}
+
+	// frame of an open object or array, key is the current object key
+	type frame struct {
+		path    string
+		isArray bool
+		index   int
+		key     string
+		wantKey bool
+	}
+
+	var stack []*frame
+	valuePath := func() string {
+		if len(stack) == 0 {
+			return ""
+		}
+
+		top := stack[len(stack)-1]
+		if top.isArray {
+			path := fmt.Sprintf("%s[%d]", top.path, top.index)
+			top.index++
+			return path
+		}
+
+		top.wantKey = true
+		return joinPath(top.path, top.key)
+	}
+
+	for {
+		offset := inputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(stack) > 0 && !stack[len(stack)-1].isArray && stack[len(stack)-1].wantKey {
+			if key, ok := token.(string); ok {
+				top := stack[len(stack)-1]
+				top.key = key
+				top.wantKey = false
+				lines[joinPath(top.path, key)] = lineAt(offset)
+				continue
+			}
+		}
+
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			path := valuePath()
+			if _, found := lines[path]; !found && path != "" {
+				lines[path] = lineAt(offset)
+			}
+
+			stack = append(stack, &frame{
+				path:    path,
+				isArray: token == json.Delim('['),
+				wantKey: token == json.Delim('{'),
+			})
+		case json.Delim('}'), json.Delim(']'):
+			stack = stack[:len(stack)-1]
+		default:
+			valuePath()
+		}
+	}
+
+	return lines, nil
+}
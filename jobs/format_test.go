@@ -0,0 +1,56 @@
+package jobs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocateJSONLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawl-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "job.json")
+	err = ioutil.WriteFile(path, []byte(`{
+  "range": {
+    "expression": "1-10",
+    "set": "page",
+    "fetch": [
+      {"url": "https://example.com/${page}"},
+      {
+        "url": "https://example.org/${page}"
+      }
+    ]
+  }
+}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines, err := locateJSONLines(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string]int{
+		"range":              2,
+		"range.expression":   3,
+		"range.set":          4,
+		"range.fetch":        5,
+		"range.fetch[0]":     6,
+		"range.fetch[0].url": 6,
+		"range.fetch[1]":     7,
+		"range.fetch[1].url": 8,
+	}
+
+	for key, expect := range expects {
+		if lines[key] != expect {
+			t.Errorf("line of %s = %d, expect %d", key, lines[key], expect)
+		}
+	}
+}
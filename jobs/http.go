@@ -0,0 +1,287 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrNotFound http response status is 404
+	ErrNotFound = errors.New("not found")
+)
+
+// InvalidResponseError response rejected by a options of the action
+type InvalidResponseError struct {
+	URL    string
+	Reason string
+}
+
+// Error describe rejected response
+func (e *InvalidResponseError) Error() string {
+	return fmt.Sprintf("invalid response of %s: %s", e.URL, e.Reason)
+}
+
+// StatusError response status is not accepted
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+// Error describe rejected status
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("response status code: %d", e.StatusCode)
+}
+
+// responseOptions checks a response must pass and how its body is decoded,
+// the zero value accepts status 200 only and detects the charset
+type responseOptions struct {
+	// status accepted status codes, e.g. 200 or 2xx, empty accepts 200
+	status []string
+	// contentTypes accepted media types, e.g. text/html or text/*, empty accepts any
+	contentTypes []string
+	// maxSize max body bytes, 0 for no limit
+	maxSize      int64
+	mustMatch    *regexp.Regexp
+	mustNotMatch *regexp.Regexp
+	// retryInvalid retry rejected responses like failed requests
+	retryInvalid bool
+	// charset body charset, empty to detect it, raw to keep body bytes
+	charset string
+}
+
+// acceptStatus check whether status code is accepted
+func (g responseOptions) acceptStatus(code int) bool {
+	if len(g.status) == 0 {
+		return code == http.StatusOK
+	}
+
+	text := strconv.Itoa(code)
+	for _, pattern := range g.status {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == text {
+			return true
+		}
+
+		if len(pattern) == 3 && strings.HasSuffix(pattern, "xx") && pattern[0] == text[0] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkHeader check content type and declared length
+func (g responseOptions) checkHeader(response *http.Response) string {
+	if g.maxSize > 0 && response.ContentLength > g.maxSize {
+		return fmt.Sprintf("content length %d exceeds max size %d", response.ContentLength, g.maxSize)
+	}
+
+	if len(g.contentTypes) == 0 {
+		return ""
+	}
+
+	header := response.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return fmt.Sprintf("invalid content type [%s]", header)
+	}
+
+	for _, pattern := range g.contentTypes {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == mediaType || pattern == "*/*" ||
+			(strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))) {
+			return ""
+		}
+	}
+
+	return fmt.Sprintf("content type %s is not one of %s", mediaType, strings.Join(g.contentTypes, ", "))
+}
+
+// checkBody check body patterns
+func (g responseOptions) checkBody(body []byte) string {
+	if g.mustMatch != nil && !g.mustMatch.Match(body) {
+		return fmt.Sprintf("body does not match %s", g.mustMatch)
+	}
+
+	if g.mustNotMatch != nil && g.mustNotMatch.Match(body) {
+		return fmt.Sprintf("body matches %s", g.mustNotMatch)
+	}
+
+	return ""
+}
+
+// httpResponse accepted response
+type httpResponse struct {
+	// Body body decompressed and decoded to utf-8
+	Body       []byte
+	StatusCode int
+	// URL final url after redirects
+	URL string
+	// Charset charset body was decoded from, empty if not decoded
+	Charset string
+}
+
+// httpGet send GET request and read response body
+//
+// 404 fails at once, other failures are retried as policy classifies them,
+// responses rejected by options only when they say so, and those not getting
+// through a proxy of pool since the next attempt picks another proxy. a nil
+// pool connects directly. every attempt takes the next user agent of client
+// unless headers set one
+func httpGet(ctx *Context, action, rawURL string, headers map[string]string, options responseOptions, client *httpClient, pool *proxyPool, policy retryPolicy) (*httpResponse, error) {
+	request, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(ctx.done())
+
+	request.Header.Set("Accept-Encoding", acceptEncoding)
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	if client == nil {
+		client = directClient
+	}
+	rotateAgent := request.Header.Get("User-Agent") == ""
+
+	host := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Host
+	}
+
+	var response *httpResponse
+	var code int
+	var selected *proxy
+	attempts := 0
+	attempt := func() error {
+		attempts++
+		if agent := client.userAgent(); rotateAgent && agent != "" {
+			request.Header.Set("User-Agent", agent)
+		}
+
+		selected = pool.pick(host)
+		ctx.L().Debug(action+" attempt",
+			zap.String("url", rawURL),
+			zap.Int("attempt", attempts),
+			zap.String("proxy", selected.String()))
+
+		span := ctx.startSpan("GET "+host, "http.url", rawURL, "attempt", strconv.Itoa(attempts), "proxy", selected.String())
+		response, code, err = doGet(client.clientFor(selected), request, host, options)
+		if code > 0 {
+			span.SetAttribute("http.status_code", strconv.Itoa(code))
+		}
+		span.End(err)
+		pool.report(selected, ctx.Err() == nil && proxyFailed(code, err))
+
+		if err != nil && selected != nil {
+			return fmt.Errorf("proxy %s: %w", selected, err)
+		}
+		return err
+	}
+
+	retryable := func(err error) bool {
+		if errors.Is(err, ErrNotFound) {
+			return false
+		}
+
+		var invalid *InvalidResponseError
+		if errors.As(err, &invalid) {
+			return options.retryInvalid
+		}
+
+		return selected != nil && proxyFailed(code, err) || policy.retryable(err)
+	}
+
+	err = policy.run(ctx, action, attempt, retryable, zap.String("url", rawURL))
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// doGet send request once, returns response status code, 0 on transport errors
+func doGet(client *http.Client, request *http.Request, host string, options responseOptions) (*httpResponse, int, error) {
+	response, err := client.Do(request)
+	if err != nil {
+		httpRequestsTotal.WithLabelValues(host, "error").Inc()
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+
+	code := response.StatusCode
+	httpRequestsTotal.WithLabelValues(host, strconv.Itoa(code)).Inc()
+
+	if !options.acceptStatus(code) {
+		if code == http.StatusNotFound {
+			return nil, code, ErrNotFound
+		}
+		return nil, code, &StatusError{URL: response.Request.URL.String(), StatusCode: code}
+	}
+
+	finalURL := response.Request.URL.String()
+	if reason := options.checkHeader(response); reason != "" {
+		return nil, code, &InvalidResponseError{URL: finalURL, Reason: reason}
+	}
+
+	counter := &countReader{reader: response.Body}
+	defer func() {
+		bytesTotal.WithLabelValues(backendHTTP, directionDownload).Add(float64(counter.count))
+	}()
+
+	decompressed, err := decompress(counter, response.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, code, err
+	}
+	defer decompressed.Close()
+
+	// the limit applies to the decompressed body
+	var reader io.Reader = decompressed
+	if options.maxSize > 0 {
+		reader = io.LimitReader(reader, options.maxSize+1)
+	}
+
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, code, err
+	}
+
+	if options.maxSize > 0 && int64(len(body)) > options.maxSize {
+		return nil, code, &InvalidResponseError{URL: finalURL, Reason: fmt.Sprintf("body exceeds max size %d", options.maxSize)}
+	}
+
+	body, charset, err := decodeCharset(body, response.Header.Get("Content-Type"), options.charset)
+	if err != nil {
+		return nil, code, err
+	}
+
+	if reason := options.checkBody(body); reason != "" {
+		return nil, code, &InvalidResponseError{URL: finalURL, Reason: reason}
+	}
+
+	return &httpResponse{Body: body, StatusCode: code, URL: finalURL, Charset: charset}, code, nil
+}
+
+// countReader count bytes read
+type countReader struct {
+	reader io.Reader
+	count  int64
+}
+
+// Read read and count
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
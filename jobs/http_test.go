@@ -0,0 +1,193 @@
+package jobs
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testSite site answering with a page per path, counting requests
+type testSite struct {
+	server *httptest.Server
+	hits   map[string]int
+	mutex  sync.Mutex
+}
+
+// newTestSite start test site
+func newTestSite() *testSite {
+	s := &testSite{hits: make(map[string]int)}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		s.hits[r.URL.Path]++
+		s.mutex.Unlock()
+
+		page := "<a>1</a>"
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			page = `{"a": "<a>1</a>"}`
+		case "/created":
+			w.WriteHeader(http.StatusCreated)
+		case "/large":
+			page += strings.Repeat(" ", 100)
+		case "/stream":
+			// flushed before the end, so the length is not declared
+			w.Write([]byte(page))
+			w.(http.Flusher).Flush()
+			page = strings.Repeat(" ", 100)
+		case "/captcha":
+			page = "<p>captcha</p>"
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write([]byte(page))
+	}))
+
+	return s
+}
+
+// count get requests of path so far
+func (s *testSite) count(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.hits[path]
+}
+
+// newTestFetch create fetch action of url capturing id, without waits between retries
+func newTestFetch(t *testing.T, url string, options Config) *Fetch {
+	config := Config{"url": url, "regexp": "<a>(\\d)</a>", "sets": []interface{}{"id"}, "retry": int64(0), "interval": "1ms", "jitter": 0.0}
+	for key, value := range options {
+		config[key] = value
+	}
+
+	action, err := newFetch(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return action.(*Fetch)
+}
+
+func TestFetchResponseOptions(t *testing.T) {
+	site := newTestSite()
+	defer site.server.Close()
+
+	invalid := func(err error) bool {
+		var e *InvalidResponseError
+		return errors.As(err, &e)
+	}
+
+	status := func(code int) func(error) bool {
+		return func(err error) bool {
+			var e *StatusError
+			return errors.As(err, &e) && e.StatusCode == code
+		}
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		options Config
+		// reject nil for accepted responses
+		reject func(error) bool
+	}{
+		{"default status", "/", nil, nil},
+		{"status not 200", "/created", nil, status(http.StatusCreated)},
+		{"status listed", "/created", Config{"status": []interface{}{"200", "201"}}, nil},
+		{"status class", "/created", Config{"status": []interface{}{"2xx"}}, nil},
+		{"status not in class", "/", Config{"status": []interface{}{"3xx"}}, status(http.StatusOK)},
+		{"server error", "/broken", nil, status(http.StatusInternalServerError)},
+		{"not found", "/missing", nil, func(err error) bool { return errors.Is(err, ErrNotFound) }},
+		{"content type", "/", Config{"content_types": []interface{}{"text/html"}}, nil},
+		{"content type class", "/json", Config{"content_types": []interface{}{"text/*", "application/*"}}, nil},
+		{"content type rejected", "/json", Config{"content_types": []interface{}{"text/html"}}, invalid},
+		{"max size", "/", Config{"max_size": int64(10)}, nil},
+		{"declared length over max size", "/large", Config{"max_size": int64(10)}, invalid},
+		{"body over max size", "/stream", Config{"max_size": int64(10)}, invalid},
+		{"must match", "/", Config{"must_match": "<a>"}, nil},
+		{"must match rejected", "/captcha", Config{"must_match": "<a>"}, invalid},
+		{"must not match rejected", "/captcha", Config{"must_not_match": "captcha"}, invalid},
+	}
+
+	for _, test := range tests {
+		options := Config{"invalid": invalidFail}
+		for key, value := range test.options {
+			options[key] = value
+		}
+
+		ctxs, err := newTestFetch(t, site.server.URL+test.path, options).Do(NewContext())
+		if test.reject == nil {
+			if err != nil || len(ctxs) != 1 {
+				t.Errorf("%s: got %d matches and error %v, expect the response accepted", test.name, len(ctxs), err)
+			}
+			continue
+		}
+
+		if !test.reject(err) {
+			t.Errorf("%s: got error %v, expect the response rejected", test.name, err)
+		}
+	}
+}
+
+func TestFetchInvalid(t *testing.T) {
+	site := newTestSite()
+	defer site.server.Close()
+
+	tests := []struct {
+		invalid string
+		// hits requests sent for the rejected page
+		hits int
+		fail bool
+		// elses else jobs run
+		elses int
+	}{
+		{invalidRetry, 3, true, 0},
+		{invalidElse, 1, false, 1},
+		{invalidFail, 1, true, 0},
+	}
+
+	for _, test := range tests {
+		before := site.count("/captcha")
+		action := newTestFetch(t, site.server.URL+"/captcha", Config{"must_match": "<a>", "invalid": test.invalid, "retry": int64(2)})
+
+		var mutex sync.Mutex
+		elses := 0
+		job := &Job{Name: "fetch", Action: action, ElseJobs: []*Job{{Name: "else", Action: doFunc(func(*Context) error {
+			mutex.Lock()
+			elses++
+			mutex.Unlock()
+			return nil
+		})}}}
+
+		err := job.Execute(NewContext())
+		if fail := err != nil; fail != test.fail {
+			t.Errorf("invalid %s: got error %v, expect failure %v", test.invalid, err, test.fail)
+		}
+
+		if hits := site.count("/captcha") - before; hits != test.hits {
+			t.Errorf("invalid %s: got %d requests, expect %d", test.invalid, hits, test.hits)
+		}
+
+		if elses != test.elses {
+			t.Errorf("invalid %s: got %d else jobs run, expect %d", test.invalid, elses, test.elses)
+		}
+	}
+
+	// a page passing the guards is fetched once
+	before := site.count("/")
+	action := newTestFetch(t, site.server.URL+"/", Config{"must_match": "<a>", "retry": int64(2)})
+	ctxs, err := action.Do(NewContext())
+	if err != nil || len(ctxs) != 1 || site.count("/")-before != 1 {
+		t.Errorf("accepted page: got %d matches and error %v after %d requests, expect 1 match after 1", len(ctxs), err, site.count("/")-before)
+	}
+}
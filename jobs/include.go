@@ -0,0 +1,222 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// includeKey top-level key listing job files to include
+	includeKey = "include"
+	// templatesKey top-level table of named reusable job subtrees
+	templatesKey = "templates"
+	// useKey action invoking a template
+	useKey = "use"
+)
+
+var (
+	// ErrIncludeCycle job files include each other
+	ErrIncludeCycle = errors.New("include cycle")
+	// ErrTemplateNotFound use unknown template
+	ErrTemplateNotFound = errors.New("template not found")
+)
+
+// loadFile decode job file and merge the files it includes
+//
+// include paths are relative to the including file, included files are merged
+// in order and the including file wins on conflicting keys
+func loadFile(filePath string, stack []string) (Config, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range stack {
+		if path == absPath {
+			return nil, fmt.Errorf("%w: %s", ErrIncludeCycle, strings.Join(append(stack, absPath), " -> "))
+		}
+	}
+
+	c, err := decodeFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	includes, err := c.includes()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	delete(c, includeKey)
+
+	merged := make(Config)
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(filePath), include)
+		}
+
+		included, err := loadFile(include, append(stack, absPath))
+		if err != nil {
+			return nil, err
+		}
+
+		mergeConfig(merged, included)
+	}
+	mergeConfig(merged, c)
+
+	return merged, nil
+}
+
+// includes get included file paths, a string or an array of strings
+func (c Config) includes() ([]string, error) {
+	value, found := c[includeKey]
+	if !found {
+		return nil, nil
+	}
+
+	if path, ok := value.(string); ok {
+		return []string{path}, nil
+	}
+
+	return c.Strings(includeKey)
+}
+
+// mergeConfig merge source into target, tables are merged recursively
+func mergeConfig(target, source Config) {
+	for key, value := range source {
+		sourceTable, sourceOk := value.(map[string]interface{})
+		targetTable, targetOk := target[key].(map[string]interface{})
+		if !sourceOk || !targetOk {
+			target[key] = value
+			continue
+		}
+
+		table := make(Config, len(targetTable))
+		mergeConfig(table, targetTable)
+		mergeConfig(table, sourceTable)
+		target[key] = map[string]interface{}(table)
+	}
+}
+
+// templateResolver injects template subtrees into use tables
+type templateResolver struct {
+	templates map[string]interface{}
+	resolved  map[string]bool
+	resolving []string
+}
+
+// resolveTemplates remove templates table and inject its subtrees into every use table
+//
+//	[templates.upload.oss_upload]
+//	bucket = "${bucket}"
+//
+//	[list.use]
+//	template = "upload"
+//	params = { bucket = "photos" }
+func resolveTemplates(c Config) error {
+	value, found := c[templatesKey]
+	if !found {
+		return nil
+	}
+	delete(c, templatesKey)
+
+	templates, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s: expect a table of templates", templatesKey)
+	}
+
+	r := &templateResolver{templates: templates, resolved: make(map[string]bool)}
+	return r.walk(c)
+}
+
+// walk resolve use tables in table and its descendants
+func (r *templateResolver) walk(table map[string]interface{}) error {
+	for key, value := range table {
+		tables, ok := toTables(value)
+		if !ok {
+			continue
+		}
+
+		for _, child := range tables {
+			err := r.walk(child)
+			if err != nil {
+				return err
+			}
+
+			if key != useKey {
+				continue
+			}
+
+			err = r.inject(child)
+			if err != nil {
+				return wrapPath(key, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// inject copy jobs of the used template into use table
+func (r *templateResolver) inject(use map[string]interface{}) error {
+	name, ok := use["template"].(string)
+	if !ok {
+		return fmt.Errorf("%w: template", ErrKeyNotFound)
+	}
+
+	template, err := r.resolve(name)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range template {
+		if _, found := use[key]; !found {
+			use[key] = value
+		}
+	}
+
+	return nil
+}
+
+// resolve get template with its own use tables resolved
+func (r *templateResolver) resolve(name string) (map[string]interface{}, error) {
+	value, found := r.templates[name]
+	if !found {
+		return nil, fmt.Errorf("%w: %s%s", ErrTemplateNotFound, name, suggest(name, r.names()))
+	}
+
+	template, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("template %s: expect a table of jobs", name)
+	}
+
+	if r.resolved[name] {
+		return template, nil
+	}
+
+	for _, resolving := range r.resolving {
+		if resolving == name {
+			return nil, fmt.Errorf("template cycle: %s", strings.Join(append(r.resolving, name), " -> "))
+		}
+	}
+
+	r.resolving = append(r.resolving, name)
+	err := r.walk(template)
+	if err != nil {
+		return nil, fmt.Errorf("template %s: %w", name, err)
+	}
+	r.resolving = r.resolving[:len(r.resolving)-1]
+	r.resolved[name] = true
+
+	return template, nil
+}
+
+func (r *templateResolver) names() []string {
+	names := make([]string, 0, len(r.templates))
+	for name := range r.templates {
+		names = append(names, name)
+	}
+
+	return names
+}
@@ -2,42 +2,137 @@ package jobs
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 	"sync"
+	"time"
 
+	"github.com/nzai/crawl/constants"
 	"go.uber.org/zap"
 )
 
 var (
 	// ErrInvalidAction invalid action
 	ErrInvalidAction = errors.New("invalid action")
+	// errStopped stream stopped because a branch failed
+	errStopped = errors.New("stream stopped")
 )
 
 // Job crawl job
 type Job struct {
+	Name string
+	// Path dotted path of the job in the job tree, e.g. range.fetch.execute,
+	// siblings sharing a name are indexed and else jobs are under name_else
+	Path     string
 	Action   interface{}
 	Jobs     []*Job
 	ElseJobs []*Job
 }
 
-// Execute execute job
+// setPaths set paths of jobs and their descendants under path
+func setPaths(path string, jobs []*Job) {
+	names := make(map[string]int)
+	for _, job := range jobs {
+		names[job.Name]++
+	}
+
+	indexes := make(map[string]int)
+	for _, job := range jobs {
+		job.Path = joinPath(path, job.Name)
+		if names[job.Name] > 1 {
+			job.Path = fmt.Sprintf("%s[%d]", job.Path, indexes[job.Name])
+			indexes[job.Name]++
+		}
+
+		setPaths(job.Path, job.Jobs)
+		setPaths(joinPath(path, job.Name+"_else"), job.ElseJobs)
+	}
+}
+
+// Execute execute job, nothing runs once the context is canceled
 func (s Job) Execute(ctx *Context) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	node := ctx.progress.node(s)
+	start := node.begin()
+	ctx, endSpan := s.traceJob(ctx)
+
+	err = s.execute(ctx)
+	if err != nil && ctx.Err() != nil {
+		// commands killed by cancellation report the cancellation
+		err = ctx.Err()
+	}
+
+	var undefined *UndefinedError
+	if errors.As(err, &undefined) && undefined.Job == "" {
+		undefined.Job = s.Path
+	}
+
+	node.end(start, err)
+	endSpan(err)
+
+	// ancestors pass the error on without counting it again
+	if err != nil && err != ctx.Err() && !isRecorded(err) {
+		err = recordedError{err}
+	}
+
+	return err
+}
+
+// recordedError error counted as failed at the job node it came from
+type recordedError struct {
+	error
+}
+
+// Unwrap get the error of the job node
+func (e recordedError) Unwrap() error {
+	return e.error
+}
+
+// isRecorded check whether err was already counted at a descendant job node
+func isRecorded(err error) bool {
+	var recorded recordedError
+	return errors.As(err, &recorded)
+}
+
+func (s Job) execute(ctx *Context) error {
+	if action, ok := s.planned(ctx); ok {
+		return s.executePlan(ctx, action)
+	}
+
 	switch s.Action.(type) {
+	case ScopedContextAction:
+		return s.executeScopedContextAction(ctx)
 	case SingleContextAction:
 		return s.executeSingleContextAction(ctx)
+	case StreamContextAction:
+		return s.executeStreamContextAction(ctx)
 	case MultipleContextAction:
 		return s.executeMultipleContextAction(ctx)
 	case ConditionContextAction:
 		return s.executeConditionContextAction(ctx)
 	default:
-		zap.L().Error("invalid action", zap.String("type", reflect.TypeOf(s.Action).String()))
+		ctx.L().Error("invalid action", zap.String("type", reflect.TypeOf(s.Action).String()))
 		return ErrInvalidAction
 	}
 }
 
+// observe record action latency and failure, streams are measured as fan-out sizes
+func (s Job) observe(start time.Time, err error) {
+	actionDuration.WithLabelValues(s.Name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		actionErrorsTotal.WithLabelValues(s.Name).Inc()
+	}
+}
+
 func (s Job) executeSingleContextAction(ctx *Context) error {
 	action := s.Action.(SingleContextAction)
+	start := time.Now()
 	err := action.Do(ctx)
+	s.observe(start, err)
 	if err != nil {
 		return err
 	}
@@ -52,16 +147,57 @@ func (s Job) executeSingleContextAction(ctx *Context) error {
 	return nil
 }
 
+func (s Job) executeScopedContextAction(ctx *Context) error {
+	action := s.Action.(ScopedContextAction)
+	start := time.Now()
+	scoped, err := action.Scope(ctx)
+	s.observe(start, err)
+	if err != nil {
+		return err
+	}
+
+	if ctx.plan != nil {
+		ctx.plan.print(ctx.depth, s.Name, scoped.describe())
+	}
+
+	node := ctx.progress.node(s)
+	node.produce()
+	defer node.branchDone()
+
+	for _, job := range s.Jobs {
+		err = job.Execute(scoped)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (s Job) executeMultipleContextAction(ctx *Context) error {
 	action := s.Action.(MultipleContextAction)
+	start := time.Now()
 	ctxs, err := action.Do(ctx)
+	s.observe(start, err)
 	if err != nil {
 		return err
 	}
 
-	if len(ctxs) == 0 {
-		for _, job := range s.ElseJobs {
-			err = job.Execute(ctx)
+	return s.fanOut(ctx, produceContexts(ctxs))
+}
+
+func (s Job) executeStreamContextAction(ctx *Context) error {
+	action := s.Action.(StreamContextAction)
+	return s.fanOut(ctx, func(yield func(*Context) error) error {
+		return action.Stream(ctx, yield)
+	})
+}
+
+// produceContexts yield contexts one by one
+func produceContexts(ctxs []*Context) func(yield func(*Context) error) error {
+	return func(yield func(*Context) error) error {
+		for _, _ctx := range ctxs {
+			err := yield(_ctx)
 			if err != nil {
 				return err
 			}
@@ -69,39 +205,213 @@ func (s Job) executeMultipleContextAction(ctx *Context) error {
 
 		return nil
 	}
+}
 
-	parallel := ctx.IntDefault("parallel", 0)
+// keptContextsWarning contexts kept for later sub jobs in job order before warning
+const keptContextsWarning = 10000
 
-	for _, job := range s.Jobs {
-		ch := make(chan bool, parallel)
-		wg := new(sync.WaitGroup)
-		wg.Add(len(ctxs))
+// fanOut run sub jobs for each produced context
+//
+// by default each sub job runs over all contexts before the next sub job
+// starts, so with several sub jobs the contexts are kept until the last one
+// is done with them and memory grows with the source. with order = "branch"
+// every context runs all sub jobs in its own branch and is dropped once the
+// branch ends, so only that order streams a large source in flat memory
+func (s Job) fanOut(ctx *Context, produce func(yield func(*Context) error) error) error {
+	scheduler := ctx.scheduler
+	if scheduler == nil {
+		scheduler = defaultScheduler
+	}
 
-		for _, _ctx := range ctxs {
-			go func(c *Context) {
-				err = job.Execute(c)
+	// a branch gives up its slot while its children run, otherwise nested
+	// fan-outs could hold every slot and wait for each other forever
+	if ctx.slot {
+		scheduler.Release(ctx.depth)
+		defer scheduler.reacquire(ctx.done().Done(), ctx.depth)
+	}
+
+	order := orderJob
+	if action, ok := s.Action.(OrderedAction); ok && action.Order() == orderBranch {
+		order = orderBranch
+	}
+
+	// plan mode prints every branch with all of its jobs
+	jobs := s.Jobs
+	var ctxs []*Context
+	jobMajor := len(s.Jobs) > 1 && order == orderJob && ctx.plan == nil
+	if jobMajor {
+		jobs = s.Jobs[:1]
+		stream := produce
+		produce = func(yield func(*Context) error) error {
+			return stream(func(c *Context) error {
+				ctxs = append(ctxs, c)
+				if len(ctxs) == keptContextsWarning {
+					ctx.L().Warn("contexts kept in memory for later child jobs, set order = \"branch\" to stream them",
+						zap.String("job", s.Path), zap.Int("contexts", len(ctxs)))
+				}
+				return yield(c)
+			})
+		}
+	}
+
+	count, err := s.runBranches(ctx, scheduler, produce, jobs, true, !jobMajor)
+	fanOutContexts.WithLabelValues(s.Path).Observe(float64(count))
+	if err != nil {
+		return err
+	}
+
+	if jobMajor {
+		for index, job := range s.Jobs[1:] {
+			last := index == len(s.Jobs)-2
+			_, err = s.runBranches(ctx, scheduler, produceContexts(ctxs), []*Job{job}, false, last)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	for _, job := range s.ElseJobs {
+		err = job.Execute(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runBranches run jobs in a branch for each produced context, counting
+// produced contexts if produced is set and finished branches if done is set
+//
+// branches take a slot from the run-wide scheduler, and this fan-out is also
+// capped by the parallel option of the action, or when that is not set by the
+// parallel value in context, one branch at a time without either. producing
+// blocks until a branch can start so contexts are never materialized ahead of
+// the workers
+func (s Job) runBranches(ctx *Context, scheduler *Scheduler, produce func(yield func(*Context) error) error, jobs []*Job, produced, done bool) (int, error) {
+	parallel := 0
+	if action, ok := s.Action.(ParallelAction); ok {
+		parallel = action.Parallel()
+	}
+
+	if parallel <= 0 {
+		parallel = ctx.IntDefault("parallel", 0)
+	}
+
+	if parallel <= 0 {
+		parallel = constants.DefaultParallel
+	}
+	ch := make(chan bool, parallel)
+
+	node := ctx.progress.node(s)
+	depth := ctx.depth + 1
+	wg := new(sync.WaitGroup)
+	stopped := make(chan bool)
+	var once sync.Once
+	var branchErr error
+	count := 0
+
+	err := produce(func(c *Context) error {
+		// plan mode runs branches serially to print them in order
+		if ctx.plan != nil {
+			count++
+			node.produce()
+			defer node.branchDone()
+
+			ctx.plan.print(ctx.depth, s.Name, c.describe())
+			for _, job := range jobs {
+				err := job.Execute(c)
 				if err != nil {
-					zap.L().Fatal("do job failed",
-						zap.Error(err),
-						zap.Any("action", job.Action))
+					return err
 				}
+			}
+
+			return nil
+		}
+
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		select {
+		case ch <- true:
+		case <-stopped:
+			return errStopped
+		case <-ctx.done().Done():
+			return ctx.Err()
+		}
 
+		err = scheduler.Acquire(ctx.done().Done(), depth)
+		if err != nil {
+			<-ch
+			return ctx.Err()
+		}
+
+		select {
+		case <-stopped:
+			scheduler.Release(depth)
+			<-ch
+			return errStopped
+		default:
+		}
+
+		c.slot = true
+		count++
+		if produced {
+			node.produce()
+		}
+		activeBranches.Inc()
+		wg.Add(1)
+		go func() {
+			defer func() {
+				activeBranches.Dec()
+				if done {
+					node.branchDone()
+				}
+				scheduler.Release(depth)
 				<-ch
 				wg.Done()
-			}(_ctx)
+			}()
 
-			ch <- true
-		}
+			for _, job := range jobs {
+				err := job.Execute(c)
+				if err != nil {
+					if err != ctx.Err() {
+						c.L().Error("do job failed",
+							zap.Error(err),
+							zap.Any("action", job.Action))
+					}
+
+					once.Do(func() {
+						branchErr = err
+						close(stopped)
+					})
+					return
+				}
+			}
+		}()
+
+		return nil
+	})
+	wg.Wait()
 
-		wg.Wait()
+	if branchErr != nil {
+		return count, branchErr
 	}
 
-	return nil
+	return count, err
 }
 
 func (s Job) executeConditionContextAction(ctx *Context) error {
 	action := s.Action.(ConditionContextAction)
+	start := time.Now()
 	_continue, err := action.Do(ctx)
+	s.observe(start, err)
 	if err != nil {
 		return err
 	}
@@ -117,6 +427,10 @@ func (s Job) executeConditionContextAction(ctx *Context) error {
 		return nil
 	}
 
+	node := ctx.progress.node(s)
+	node.produce()
+	defer node.branchDone()
+
 	for _, job := range s.Jobs {
 		err = job.Execute(ctx)
 		if err != nil {
@@ -132,11 +446,75 @@ type SingleContextAction interface {
 	Do(*Context) error
 }
 
+// ScopedContextAction action running its sub jobs inline on a child context,
+// so values it sets only reach its sub jobs
+type ScopedContextAction interface {
+	Scope(*Context) (*Context, error)
+}
+
 // MultipleContextAction action results multiple contexts
 type MultipleContextAction interface {
 	Do(*Context) ([]*Context, error)
 }
 
+// StreamContextAction action yields multiple contexts one by one,
+// yield blocks while all branches are busy and returns an error to stop the stream
+type StreamContextAction interface {
+	Stream(ctx *Context, yield func(*Context) error) error
+}
+
+// ParallelAction action caps its concurrent branches, 0 for no cap
+type ParallelAction interface {
+	Parallel() int
+}
+
+// OrderedAction action choosing the order its sub jobs run in, job or branch
+type OrderedAction interface {
+	Order() string
+}
+
+// orders of the sub jobs of fan-out actions
+const (
+	// orderJob each sub job runs over all contexts before the next one starts
+	orderJob = "job"
+	// orderBranch each context runs all sub jobs in its own branch
+	orderBranch = "branch"
+)
+
+// ordering order option of fan-out actions
+type ordering struct {
+	order string
+}
+
+// newOrdering read order option of fan-out action
+func newOrdering(c *Config) (ordering, error) {
+	order := c.StringDefault("order", orderJob)
+	if order != orderJob && order != orderBranch {
+		return ordering{}, fmt.Errorf("invalid order [%s], expect %s or %s", order, orderJob, orderBranch)
+	}
+
+	return ordering{order: order}, nil
+}
+
+// Order get order of sub jobs
+func (o ordering) Order() string {
+	return o.order
+}
+
+// collectContexts collect all streamed contexts
+func collectContexts(ctx *Context, stream func(*Context, func(*Context) error) error) ([]*Context, error) {
+	var ctxs []*Context
+	err := stream(ctx, func(c *Context) error {
+		ctxs = append(ctxs, c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ctxs, nil
+}
+
 // ConditionContextAction action results condition context
 type ConditionContextAction interface {
 	Do(*Context) (bool, error)
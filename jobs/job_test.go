@@ -0,0 +1,408 @@
+package jobs
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// benchmarkContexts contexts produced by every fan-out benchmark
+const benchmarkContexts = 100000
+
+// doFunc single context action running a function
+type doFunc func(*Context) error
+
+// Do do job
+func (f doFunc) Do(ctx *Context) error {
+	return f(ctx)
+}
+
+// materialized fan-out action without its stream, so all contexts are built first
+type materialized struct {
+	action MultipleContextAction
+}
+
+// Do do job
+func (m materialized) Do(ctx *Context) ([]*Context, error) {
+	return m.action.Do(ctx)
+}
+
+// recorder records names of steps run concurrently
+type recorder struct {
+	steps []string
+	mutex sync.Mutex
+}
+
+// step single context action recording name and page
+func (r *recorder) step(name string) doFunc {
+	return func(ctx *Context) error {
+		page, err := ctx.String("page")
+		if err != nil {
+			return err
+		}
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		r.steps = append(r.steps, name+page)
+		return nil
+	}
+}
+
+func TestFanOutOrder(t *testing.T) {
+	tests := []struct {
+		order    string
+		parallel int
+		expect   string
+	}{
+		// every page fetched before any is uploaded, whatever the parallelism
+		{orderJob, 3, "fetch,fetch,fetch,upload,upload,upload"},
+		{orderJob, 1, "fetch1,fetch2,fetch3,upload1,upload2,upload3"},
+		{orderBranch, 1, "fetch1,upload1,fetch2,upload2,fetch3,upload3"},
+	}
+
+	for _, test := range tests {
+		r := new(recorder)
+		action := &Range{start: "1", end: "3", set: "page", parallel: test.parallel, ordering: ordering{order: test.order}}
+		job := &Job{Name: "range", Action: action, Jobs: []*Job{
+			{Name: "fetch", Action: r.step("fetch")},
+			{Name: "upload", Action: r.step("upload")},
+		}}
+
+		err := job.Execute(NewContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		steps := r.steps
+		if test.parallel > 1 {
+			// concurrent branches finish in any order, only compare the steps
+			for index, step := range steps {
+				steps[index] = strings.TrimRight(step, "0123456789")
+			}
+		}
+
+		if got := strings.Join(steps, ","); got != test.expect {
+			t.Errorf("order %s parallel %d: got %s, expect %s", test.order, test.parallel, got, test.expect)
+		}
+	}
+}
+
+// counter stream action yielding total contexts, counting yields ahead of
+// the branches finished by its last child job
+type counter struct {
+	ordering
+	total    int
+	parallel int
+	mutex    sync.Mutex
+	yielded  int
+	finished int
+	ahead    int
+}
+
+// Stream yield contexts one by one
+func (c *counter) Stream(ctx *Context, yield func(*Context) error) error {
+	for index := 0; index < c.total; index++ {
+		c.mutex.Lock()
+		c.yielded++
+		if c.yielded-c.finished > c.ahead {
+			c.ahead = c.yielded - c.finished
+		}
+		c.mutex.Unlock()
+
+		err := yield(ctx.Clone())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Parallel get parallel option
+func (c *counter) Parallel() int {
+	return c.parallel
+}
+
+// finish child job counting finished branches
+func (c *counter) finish(ctx *Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.finished++
+	return nil
+}
+
+func TestFanOutBranchStreams(t *testing.T) {
+	tests := []struct {
+		order  string
+		expect func(ahead int) bool
+	}{
+		// a context is yielded only when a branch is free, at most parallel
+		// running and one waiting for a slot
+		{orderBranch, func(ahead int) bool { return ahead <= 3 }},
+		// the last child job only starts once every context is yielded
+		{orderJob, func(ahead int) bool { return ahead == 100 }},
+	}
+
+	for _, test := range tests {
+		action := &counter{ordering: ordering{order: test.order}, total: 100, parallel: 2}
+		job := &Job{Name: "stream", Action: action, Jobs: []*Job{
+			{Name: "first", Action: doFunc(func(*Context) error { return nil })},
+			{Name: "last", Action: doFunc(action.finish)},
+		}}
+
+		err := job.Execute(NewContext())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if action.finished != action.total {
+			t.Errorf("order %s: got %d finished branches, expect %d", test.order, action.finished, action.total)
+		}
+
+		if !test.expect(action.ahead) {
+			t.Errorf("order %s: got %d contexts yielded ahead of finished branches", test.order, action.ahead)
+		}
+	}
+}
+
+// maxBranches run action with a child job, get the most branches running at once
+func maxBranches(t *testing.T, action interface{}, ctx *Context) int {
+	var mutex sync.Mutex
+	running, max := 0, 0
+	child := doFunc(func(ctx *Context) error {
+		mutex.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		mutex.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		return nil
+	})
+
+	job := &Job{Name: "fan_out", Action: action, Jobs: []*Job{{Name: "child", Action: child}}}
+	err := job.Execute(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return max
+}
+
+func TestParallelPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawl-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for index := 0; index < 6; index++ {
+		err = os.Mkdir(filepath.Join(dir, fmt.Sprintf("d%d", index)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("f%d.txt", index)), nil, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page := strings.Repeat("<a>1</a>", 6)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, page)
+	}))
+	defer server.Close()
+
+	actions := map[string]Config{
+		"range":    {"expression": "1-6", "set": "page"},
+		"list":     {"path": dir, "pattern": "*.txt", "path_set": "path", "name_set": "name"},
+		"list_dir": {"path": dir, "pattern": "d*", "path_set": "path", "name_set": "name"},
+		"match":    {"content": page, "regexp": "<a>(\\d)</a>", "sets": []interface{}{"id"}},
+		"fetch":    {"url": server.URL, "regexp": "<a>(\\d)</a>", "sets": []interface{}{"id"}},
+	}
+
+	tests := []struct {
+		option  int
+		context int
+		run     int
+		expect  int
+	}{
+		// one branch at a time without any parallel setting
+		{0, 0, 0, 1},
+		// the parallel value in context when the action has no option
+		{0, 3, 0, 3},
+		// the option of the action over the context
+		{2, 3, 0, 2},
+		{4, 0, 0, 4},
+		// the run-wide cap over both
+		{4, 3, 2, 2},
+	}
+
+	resources, err := readResources(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, options := range actions {
+		for _, test := range tests {
+			config := make(Config)
+			for key, value := range options {
+				config[key] = value
+			}
+
+			if test.option > 0 {
+				config["parallel"] = int64(test.option)
+			}
+
+			job, err := (&Config{}).toJob(name, &config)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			err = setResources([]*Job{job}, resources)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			ctx := NewContext()
+			ctx.SetScheduler(NewScheduler(test.run, nil))
+			if test.context > 0 {
+				ctx.SetValue("parallel", test.context)
+			}
+
+			got := maxBranches(t, job.Action, ctx)
+			if got != test.expect {
+				t.Errorf("%s with option %d, context %d and run-wide %d: got %d branches, expect %d",
+					name, test.option, test.context, test.run, got, test.expect)
+			}
+		}
+	}
+}
+
+// benchmarkFanOut run action with a no-op child job, reporting the time until
+// the first child runs and the heap held at that moment
+func benchmarkFanOut(b *testing.B, action interface{}) {
+	var firstChild time.Duration
+	var heldBytes uint64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		runtime.GC()
+		var before runtime.MemStats
+		runtime.ReadMemStats(&before)
+		var once sync.Once
+		b.StartTimer()
+
+		start := time.Now()
+		child := doFunc(func(ctx *Context) error {
+			once.Do(func() {
+				firstChild += time.Since(start)
+
+				var stats runtime.MemStats
+				runtime.ReadMemStats(&stats)
+				if stats.HeapAlloc > before.HeapAlloc {
+					heldBytes += stats.HeapAlloc - before.HeapAlloc
+				}
+			})
+			return nil
+		})
+
+		job := &Job{Name: "fan_out", Action: action, Jobs: []*Job{{Name: "child", Action: child}}}
+
+		ctx := NewContext()
+		ctx.SetScheduler(NewScheduler(8, nil))
+		err := job.Execute(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(firstChild.Nanoseconds())/float64(b.N), "ns/first-child")
+	b.ReportMetric(float64(heldBytes)/float64(b.N), "B-held/first-child")
+}
+
+func newBenchmarkRange() *Range {
+	return &Range{start: "1", end: strconv.Itoa(benchmarkContexts), set: "page"}
+}
+
+func BenchmarkRangeMaterialized(b *testing.B) {
+	benchmarkFanOut(b, materialized{newBenchmarkRange()})
+}
+
+func BenchmarkRangeStream(b *testing.B) {
+	benchmarkFanOut(b, newBenchmarkRange())
+}
+
+// newBenchmarkDirs create 100 dirs of 100 dirs each under a temp dir
+func newBenchmarkDirs(b *testing.B) (*ListDir, func()) {
+	root, err := ioutil.TempDir("", "crawl-bench-")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for outer := 0; outer < 100; outer++ {
+		for inner := 0; inner < 100; inner++ {
+			err = os.MkdirAll(filepath.Join(root, fmt.Sprintf("d%03d", outer), fmt.Sprintf("d%03d", inner)), 0755)
+			if err != nil {
+				os.RemoveAll(root)
+				b.Fatal(err)
+			}
+		}
+	}
+
+	action := &ListDir{path: root, pattern: "d*", recursive: true, pathSet: "path", nameSet: "name"}
+	return action, func() { os.RemoveAll(root) }
+}
+
+func BenchmarkListDirMaterialized(b *testing.B) {
+	action, remove := newBenchmarkDirs(b)
+	defer remove()
+
+	benchmarkFanOut(b, materialized{action})
+}
+
+func BenchmarkListDirStream(b *testing.B) {
+	action, remove := newBenchmarkDirs(b)
+	defer remove()
+
+	benchmarkFanOut(b, action)
+}
+
+// BenchmarkContextClone clone children of a context deep in the tree,
+// children only store their own keys whatever the ancestors hold
+func BenchmarkContextClone(b *testing.B) {
+	ctx := NewContext()
+	for depth := 0; depth < 5; depth++ {
+		for index := 0; index < 20; index++ {
+			ctx.Set(fmt.Sprintf("key%d_%d", depth, index), "value")
+		}
+		ctx = ctx.Clone()
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		child := ctx.Clone()
+		child.SetValue("page", n)
+	}
+}
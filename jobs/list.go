@@ -16,8 +16,8 @@ type List struct {
 	pathSet   string
 	nameSet   string
 	parallel  int
-	files     []string
 	debug     bool
+	ordering
 }
 
 // newList create list action
@@ -46,6 +46,11 @@ func newList(c *Config) (interface{}, error) {
 
 	parallel := c.IntDefault("parallel", 0)
 
+	ordering, err := newOrdering(c)
+	if err != nil {
+		return nil, err
+	}
+
 	debug := c.BoolDefault("debug", false)
 
 	return &List{
@@ -56,61 +61,78 @@ func newList(c *Config) (interface{}, error) {
 		nameSet:   nameSet,
 		parallel:  parallel,
 		debug:     debug,
+		ordering:  ordering,
 	}, nil
 }
 
+// Parallel get max concurrent branches
+func (s List) Parallel() int {
+	return s.parallel
+}
+
+// Attributes describe expanded options for tracing
+func (s List) Attributes(ctx *Context) map[string]string {
+	return map[string]string{"path": expandAttribute(ctx, s.path), "pattern": s.pattern}
+}
+
 // Do do job
 func (s List) Do(ctx *Context) ([]*Context, error) {
-	dir := ctx.Expand(s.path)
-	var err error
-	if s.recursive {
-		err = s.work(dir)
-	} else {
-		err = s.glob(dir)
-	}
+	return collectContexts(ctx, s.Stream)
+}
 
+// Stream yield context for each file
+func (s List) Stream(ctx *Context, yield func(*Context) error) error {
+	dir, err := ctx.Expand(s.path)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	ctxs := make([]*Context, len(s.files))
-	for index, file := range s.files {
+	emit := func(file string) error {
 		cloneCtx := ctx.Clone()
 		cloneCtx.Set(s.pathSet, file)
 		cloneCtx.Set(s.nameSet, filepath.Base(file))
 
-		ctxs[index] = cloneCtx
+		return yield(cloneCtx)
+	}
+
+	if s.recursive {
+		return s.work(ctx, dir, emit)
 	}
 
-	return ctxs, nil
+	return s.glob(ctx, dir, emit)
 }
 
-func (s *List) glob(dir string) error {
+func (s List) glob(ctx *Context, dir string, emit func(string) error) error {
 	files, err := filepath.Glob(filepath.Join(dir, s.pattern))
 	if err != nil {
-		zap.L().Warn("glob dir failed",
+		ctx.L().Warn("glob dir failed",
 			zap.Error(err),
 			zap.String("dir", dir))
 		return err
 	}
 
 	if s.debug {
-		zap.L().Debug("glob dir success",
+		ctx.L().Debug("glob dir success",
 			zap.String("dir", dir),
 			zap.String("pattern", s.pattern),
 			zap.Int("matches", len(files)),
 			zap.Strings("files", files))
 	}
 
-	s.files = append(s.files, files...)
+	for _, file := range files {
+		err = emit(file)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-func (s *List) work(dir string) error {
+func (s List) work(ctx *Context, dir string, emit func(string) error) error {
 	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			zap.L().Warn("walk dir failed",
+			ctx.L().Warn("walk dir failed",
 				zap.Error(err),
 				zap.String("dir", dir))
 			return err
@@ -122,7 +144,7 @@ func (s *List) work(dir string) error {
 
 		match, err := filepath.Match(s.pattern, info.Name())
 		if err != nil {
-			zap.L().Warn("match file failed",
+			ctx.L().Warn("match file failed",
 				zap.Error(err),
 				zap.String("dir", dir),
 				zap.String("name", info.Name()),
@@ -134,24 +156,22 @@ func (s *List) work(dir string) error {
 			return nil
 		}
 
-		s.files = append(s.files, path)
-
 		if s.debug {
-			zap.L().Debug("find file",
+			ctx.L().Debug("find file",
 				zap.String("path", path),
 				zap.String("file", info.Name()))
 		}
 
-		return nil
+		return emit(path)
 	})
-	if err != nil {
-		zap.L().Error("work dir failed",
+	if err != nil && err != errStopped {
+		ctx.L().Error("work dir failed",
 			zap.Error(err),
 			zap.String("dir", dir))
 		return err
 	}
 
-	return nil
+	return err
 }
 
 // ListDir list dirs
@@ -162,8 +182,8 @@ type ListDir struct {
 	pathSet   string
 	nameSet   string
 	parallel  int
-	dirs      []string
 	debug     bool
+	ordering
 }
 
 // newListDir create list dir action
@@ -192,6 +212,11 @@ func newListDir(c *Config) (interface{}, error) {
 
 	parallel := c.IntDefault("parallel", 0)
 
+	ordering, err := newOrdering(c)
+	if err != nil {
+		return nil, err
+	}
+
 	debug := c.BoolDefault("debug", false)
 
 	return &ListDir{
@@ -202,45 +227,56 @@ func newListDir(c *Config) (interface{}, error) {
 		nameSet:   nameSet,
 		parallel:  parallel,
 		debug:     debug,
+		ordering:  ordering,
 	}, nil
 }
 
+// Parallel get max concurrent branches
+func (s ListDir) Parallel() int {
+	return s.parallel
+}
+
+// Attributes describe expanded options for tracing
+func (s ListDir) Attributes(ctx *Context) map[string]string {
+	return map[string]string{"path": expandAttribute(ctx, s.path), "pattern": s.pattern}
+}
+
 // Do do job
 func (s ListDir) Do(ctx *Context) ([]*Context, error) {
-	dir := ctx.Expand(s.path)
-	var err error
-	if s.recursive {
-		err = s.work(dir)
-	} else {
-		err = s.glob(dir)
-	}
+	return collectContexts(ctx, s.Stream)
+}
 
+// Stream yield context for each dir
+func (s ListDir) Stream(ctx *Context, yield func(*Context) error) error {
+	dir, err := ctx.Expand(s.path)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	ctxs := make([]*Context, len(s.dirs))
-	for index, file := range s.dirs {
+	emit := func(file string) error {
 		cloneCtx := ctx.Clone()
 		cloneCtx.Set(s.pathSet, file)
 		cloneCtx.Set(s.nameSet, filepath.Base(file))
 
-		ctxs[index] = cloneCtx
+		return yield(cloneCtx)
+	}
+
+	if s.recursive {
+		return s.work(ctx, dir, emit)
 	}
 
-	return ctxs, nil
+	return s.glob(ctx, dir, emit)
 }
 
-func (s *ListDir) glob(dir string) error {
+func (s ListDir) glob(ctx *Context, dir string, emit func(string) error) error {
 	fis, err := ioutil.ReadDir(dir)
 	if err != nil {
-		zap.L().Warn("read dir failed",
+		ctx.L().Warn("read dir failed",
 			zap.Error(err),
 			zap.String("dir", dir))
 		return err
 	}
 
-	dirs := make([]string, 0, len(fis))
 	for _, fi := range fis {
 		if !fi.IsDir() {
 			continue
@@ -248,7 +284,7 @@ func (s *ListDir) glob(dir string) error {
 
 		match, err := filepath.Match(s.pattern, fi.Name())
 		if err != nil {
-			zap.L().Warn("match dir failed",
+			ctx.L().Warn("match dir failed",
 				zap.Error(err),
 				zap.String("dir", dir),
 				zap.String("name", fi.Name()),
@@ -262,22 +298,23 @@ func (s *ListDir) glob(dir string) error {
 
 		_dir := filepath.Join(dir, fi.Name())
 		if s.debug {
-			zap.L().Debug("find dir success",
+			ctx.L().Debug("find dir success",
 				zap.String("dir", _dir))
 		}
 
-		dirs = append(dirs, _dir)
+		err = emit(_dir)
+		if err != nil {
+			return err
+		}
 	}
 
-	s.dirs = append(s.dirs, dirs...)
-
 	return nil
 }
 
-func (s *ListDir) work(dir string) error {
+func (s ListDir) work(ctx *Context, dir string, emit func(string) error) error {
 	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			zap.L().Warn("walk dir failed",
+			ctx.L().Warn("walk dir failed",
 				zap.Error(err),
 				zap.String("dir", dir))
 			return err
@@ -289,7 +326,7 @@ func (s *ListDir) work(dir string) error {
 
 		match, err := filepath.Match(s.pattern, info.Name())
 		if err != nil {
-			zap.L().Warn("match dir failed",
+			ctx.L().Warn("match dir failed",
 				zap.Error(err),
 				zap.String("dir", dir),
 				zap.String("name", info.Name()),
@@ -301,22 +338,20 @@ func (s *ListDir) work(dir string) error {
 			return nil
 		}
 
-		s.dirs = append(s.dirs, path)
-
 		if s.debug {
-			zap.L().Debug("find dir",
+			ctx.L().Debug("find dir",
 				zap.String("path", path),
 				zap.String("dir", info.Name()))
 		}
 
-		return nil
+		return emit(path)
 	})
-	if err != nil {
-		zap.L().Error("work dir failed",
+	if err != nil && err != errStopped {
+		ctx.L().Error("work dir failed",
 			zap.Error(err),
 			zap.String("dir", dir))
 		return err
 	}
 
-	return nil
+	return err
 }
@@ -8,10 +8,12 @@ import (
 
 // Match http get html and match regexp
 type Match struct {
-	content string
-	regexp  *regexp.Regexp
-	sets    []string
-	debug   bool
+	content  string
+	regexp   *regexp.Regexp
+	sets     []string
+	parallel int
+	debug    bool
+	ordering
 }
 
 // newMatch create match action
@@ -39,23 +41,40 @@ func newMatch(c *Config) (interface{}, error) {
 		return nil, err
 	}
 
+	parallel := c.IntDefault("parallel", 0)
+
+	ordering, err := newOrdering(c)
+	if err != nil {
+		return nil, err
+	}
+
 	debug := c.BoolDefault("debug", false)
 
 	return &Match{
-		content: content,
-		regexp:  regex,
-		sets:    sets,
-		debug:   debug,
+		content:  content,
+		regexp:   regex,
+		sets:     sets,
+		parallel: parallel,
+		debug:    debug,
+		ordering: ordering,
 	}, nil
 }
 
+// Parallel get max concurrent branches
+func (s Match) Parallel() int {
+	return s.parallel
+}
+
 // Do do job
 func (s Match) Do(ctx *Context) ([]*Context, error) {
-	content := ctx.Expand(s.content)
+	content, err := ctx.Expand(s.content)
+	if err != nil {
+		return nil, err
+	}
 
 	groups := s.regexp.FindAllStringSubmatch(content, -1)
 	if s.debug {
-		zap.L().Debug("match content success",
+		ctx.L().Debug("match content success",
 			zap.String("content", content),
 			zap.String("expression", s.regexp.String()),
 			zap.Int("matches", len(groups)))
@@ -69,10 +88,10 @@ func (s Match) Do(ctx *Context) ([]*Context, error) {
 
 		cloneCtx := ctx.Clone()
 		for keyIndex, key := range s.sets {
-			cloneCtx.Set(key, group[keyIndex+1])
+			cloneCtx.SetValue(key, capturedValue(group[keyIndex+1]))
 
 			if s.debug {
-				zap.L().Debug("set match context success",
+				ctx.L().Debug("set match context success",
 					zap.String("key", key),
 					zap.String("value", group[keyIndex+1]))
 			}
@@ -0,0 +1,85 @@
+package jobs
+
+import (
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// storage backends and transfer directions of bytes metric
+const (
+	backendHTTP       = "http"
+	backendOss        = "oss"
+	backendCos        = "cos"
+	backendBrowser    = "browser"
+	directionDownload = "download"
+	directionUpload   = "upload"
+)
+
+// prometheus metrics of the job engine, registered to the default registry
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "crawl",
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by host and status code, code is error for transport errors.",
+	}, []string{"host", "code"})
+
+	bytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "crawl",
+		Name:      "bytes_total",
+		Help:      "Bytes transferred by backend and direction.",
+	}, []string{"backend", "direction"})
+
+	fanOutContexts = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "crawl",
+		Name:      "fanout_contexts",
+		Help:      "Contexts produced by one execution of a fan-out job.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+	}, []string{"job"})
+
+	activeBranches = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "crawl",
+		Name:      "active_branches",
+		Help:      "Fan-out branches running their child jobs.",
+	})
+
+	waitingBranches = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "crawl",
+		Name:      "waiting_branches",
+		Help:      "Fan-out branches waiting for a slot by depth, 1 for top-level fan-outs.",
+	}, []string{"depth"})
+
+	retriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "crawl",
+		Name:      "retries_total",
+		Help:      "Retried attempts by action.",
+	}, []string{"action"})
+
+	proxyEjectionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "crawl",
+		Name:      "proxy_ejections_total",
+		Help:      "Proxies ejected from their pool after repeated failures.",
+	}, []string{"pool"})
+
+	actionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "crawl",
+		Name:      "action_duration_seconds",
+		Help:      "Time spent in the action itself, excluding its child jobs.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"action"})
+
+	actionErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "crawl",
+		Name:      "action_errors_total",
+		Help:      "Failed action executions by action.",
+	}, []string{"action"})
+)
+
+// observeFileBytes count size of transferred file
+func observeFileBytes(backend, direction, path string) {
+	info, err := os.Stat(path)
+	if err == nil {
+		bytesTotal.WithLabelValues(backend, direction).Add(float64(info.Size()))
+	}
+}
@@ -14,6 +14,7 @@ type OssExists struct {
 	key        string
 	toContinue bool
 	debug      bool
+	retrying
 }
 
 // newOssExists create ossExists action
@@ -50,6 +51,11 @@ func newOssExists(c *Config) (interface{}, error) {
 
 	debug := c.BoolDefault("debug", false)
 
+	retrying, err := newRetrying(c)
+	if err != nil {
+		return nil, err
+	}
+
 	return &OssExists{
 		endPoint:   endPoint,
 		keyID:      keyID,
@@ -58,35 +64,57 @@ func newOssExists(c *Config) (interface{}, error) {
 		key:        key,
 		toContinue: _continue,
 		debug:      debug,
+		retrying:   retrying,
 	}, nil
 }
 
+// Plan describe check in plan mode
+func (s OssExists) Plan(ctx *Context) (string, error) {
+	bucket, err := ctx.Expand(s.bucket)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := ctx.Expand(s.key)
+	if err != nil {
+		return "", err
+	}
+
+	return "check " + "oss://" + bucket + "/" + key + " exists, assume continue", nil
+}
+
+// Attributes describe expanded options for tracing
+func (s OssExists) Attributes(ctx *Context) map[string]string {
+	return map[string]string{
+		"bucket": expandAttribute(ctx, s.bucket),
+		"key":    expandAttribute(ctx, s.key),
+	}
+}
+
 // Do do job
 func (s OssExists) Do(ctx *Context) (bool, error) {
-	client, err := oss.New(s.endPoint, s.keyID, s.keySecret)
+	bucket, err := openOssBucket(ctx, s.endPoint, s.keyID, s.keySecret, s.bucket)
 	if err != nil {
-		zap.L().Error("create new aliyun oss client failed",
-			zap.Error(err),
-			zap.String("endpoint", s.endPoint),
-			zap.String("accessKeyID", s.keyID),
-			zap.String("accessKeySecret", s.keySecret))
 		return false, err
 	}
 
-	bucket, err := client.Bucket(s.bucket)
+	key, err := ctx.Expand(s.key)
 	if err != nil {
-		zap.L().Error("get aliyun oss bucket failed",
-			zap.Error(err),
-			zap.String("bucket", s.bucket))
 		return false, err
 	}
 
-	key := ctx.Expand(s.key)
-	exists, err := bucket.IsObjectExist(key)
+	var exists bool
+	err = s.retry.run(ctx, "oss_exists", func() error {
+		span := ctx.startSpan("oss.IsObjectExist", "bucket", bucket.BucketName, "key", key)
+		var err error
+		exists, err = bucket.IsObjectExist(key)
+		span.End(err)
+		return err
+	}, nil, zap.String("bucket", bucket.BucketName), zap.String("key", key))
 	if err != nil {
-		zap.L().Error("check object exists failed",
+		ctx.L().Error("check object exists failed",
 			zap.Error(err),
-			zap.String("bucket", s.bucket),
+			zap.String("bucket", bucket.BucketName),
 			zap.String("key", key))
 		return false, err
 	}
@@ -102,8 +130,8 @@ func (s OssExists) Do(ctx *Context) (bool, error) {
 			status = "aliyun oss object not exists"
 		}
 
-		zap.L().Debug(status,
-			zap.String("bucket", s.bucket),
+		ctx.L().Debug(status,
+			zap.String("bucket", bucket.BucketName),
 			zap.String("key", key),
 			zap.Bool("continue", _continue))
 	}
@@ -120,6 +148,7 @@ type OssUpload struct {
 	key       string
 	path      string
 	debug     bool
+	retrying
 }
 
 // newOssUpload create ossUpload action
@@ -156,6 +185,11 @@ func newOssUpload(c *Config) (interface{}, error) {
 
 	debug := c.BoolDefault("debug", false)
 
+	retrying, err := newRetrying(c)
+	if err != nil {
+		return nil, err
+	}
+
 	return &OssUpload{
 		path:      path,
 		endPoint:  endPoint,
@@ -164,46 +198,77 @@ func newOssUpload(c *Config) (interface{}, error) {
 		bucket:    bucket,
 		key:       key,
 		debug:     debug,
+		retrying:  retrying,
 	}, nil
 }
 
+// Plan describe upload in plan mode
+func (s OssUpload) Plan(ctx *Context) (string, error) {
+	bucket, err := ctx.Expand(s.bucket)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := ctx.Expand(s.key)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := ctx.Expand(s.path)
+	if err != nil {
+		return "", err
+	}
+
+	return "upload " + path + " to " + "oss://" + bucket + "/" + key, nil
+}
+
+// Attributes describe expanded options for tracing
+func (s OssUpload) Attributes(ctx *Context) map[string]string {
+	return map[string]string{
+		"bucket": expandAttribute(ctx, s.bucket),
+		"key":    expandAttribute(ctx, s.key),
+		"path":   expandAttribute(ctx, s.path),
+	}
+}
+
 // Do do job
 func (s OssUpload) Do(ctx *Context) error {
-	client, err := oss.New(s.endPoint, s.keyID, s.keySecret)
+	bucket, err := openOssBucket(ctx, s.endPoint, s.keyID, s.keySecret, s.bucket)
 	if err != nil {
-		zap.L().Error("create new aliyun oss client failed",
-			zap.Error(err),
-			zap.String("endpoint", s.endPoint),
-			zap.String("accessKeyID", s.keyID),
-			zap.String("accessKeySecret", s.keySecret))
 		return err
 	}
 
-	bucket, err := client.Bucket(s.bucket)
+	key, err := ctx.Expand(s.key)
 	if err != nil {
-		zap.L().Error("get aliyun oss bucket failed",
-			zap.Error(err),
-			zap.String("bucket", s.bucket))
 		return err
 	}
 
-	key := ctx.Expand(s.key)
-	path := ctx.Expand(s.path)
+	path, err := ctx.Expand(s.path)
+	if err != nil {
+		return err
+	}
 
-	err = bucket.UploadFile(key, path, 1024*1024)
+	err = s.retry.run(ctx, "oss_upload", func() error {
+		span := ctx.startSpan("oss.UploadFile", "bucket", bucket.BucketName, "key", key, "path", path)
+		err := bucket.UploadFile(key, path, 1024*1024)
+		span.End(err)
+		return err
+	}, nil, zap.String("bucket", bucket.BucketName), zap.String("key", key), zap.String("path", path))
 	if err != nil {
-		zap.L().Error("upload file to aliyun oss bucket failed",
+		ctx.L().Error("upload file to aliyun oss bucket failed",
 			zap.Error(err),
 			zap.String("path", path),
-			zap.String("bucket", s.bucket),
+			zap.String("bucket", bucket.BucketName),
 			zap.String("key", key))
 		return err
 	}
 
+	observeFileBytes(backendOss, directionUpload, path)
+
 	if s.debug {
-		zap.L().Debug("upload file to aliyun oss bucket success",
+		ctx.L().Debug("upload file to aliyun oss bucket success",
 			zap.String("path", path),
-			zap.String("bucket", s.bucket),
+			zap.String("bucket", bucket.BucketName),
 			zap.String("key", key))
 	}
 
@@ -219,6 +284,7 @@ type OssDownload struct {
 	key       string
 	path      string
 	debug     bool
+	retrying
 }
 
 // newOssDownload create ossDownload action
@@ -255,6 +321,11 @@ func newOssDownload(c *Config) (interface{}, error) {
 
 	debug := c.BoolDefault("debug", false)
 
+	retrying, err := newRetrying(c)
+	if err != nil {
+		return nil, err
+	}
+
 	return &OssDownload{
 		path:      path,
 		endPoint:  endPoint,
@@ -263,48 +334,122 @@ func newOssDownload(c *Config) (interface{}, error) {
 		bucket:    bucket,
 		key:       key,
 		debug:     debug,
+		retrying:  retrying,
 	}, nil
 }
 
+// Plan describe download in plan mode
+func (s OssDownload) Plan(ctx *Context) (string, error) {
+	bucket, err := ctx.Expand(s.bucket)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := ctx.Expand(s.key)
+	if err != nil {
+		return "", err
+	}
+
+	path, err := ctx.Expand(s.path)
+	if err != nil {
+		return "", err
+	}
+
+	return "download " + "oss://" + bucket + "/" + key + " to " + path, nil
+}
+
+// Attributes describe expanded options for tracing
+func (s OssDownload) Attributes(ctx *Context) map[string]string {
+	return map[string]string{
+		"bucket": expandAttribute(ctx, s.bucket),
+		"key":    expandAttribute(ctx, s.key),
+		"path":   expandAttribute(ctx, s.path),
+	}
+}
+
 // Do do job
 func (s OssDownload) Do(ctx *Context) error {
-	client, err := oss.New(s.endPoint, s.keyID, s.keySecret)
+	bucket, err := openOssBucket(ctx, s.endPoint, s.keyID, s.keySecret, s.bucket)
 	if err != nil {
-		zap.L().Error("create new aliyun oss client failed",
-			zap.Error(err),
-			zap.String("endpoint", s.endPoint),
-			zap.String("accessKeyID", s.keyID),
-			zap.String("accessKeySecret", s.keySecret))
 		return err
 	}
 
-	bucket, err := client.Bucket(s.bucket)
+	key, err := ctx.Expand(s.key)
 	if err != nil {
-		zap.L().Error("get aliyun oss bucket failed",
-			zap.Error(err),
-			zap.String("bucket", s.bucket))
 		return err
 	}
 
-	key := ctx.Expand(s.key)
-	path := ctx.Expand(s.path)
+	path, err := ctx.Expand(s.path)
+	if err != nil {
+		return err
+	}
 
-	err = bucket.GetObjectToFile(key, path)
+	err = s.retry.run(ctx, "oss_download", func() error {
+		span := ctx.startSpan("oss.GetObjectToFile", "bucket", bucket.BucketName, "key", key, "path", path)
+		err := bucket.GetObjectToFile(key, path)
+		span.End(err)
+		return err
+	}, nil, zap.String("bucket", bucket.BucketName), zap.String("key", key), zap.String("path", path))
 	if err != nil {
-		zap.L().Error("download file from aliyun oss bucket failed",
+		ctx.L().Error("download file from aliyun oss bucket failed",
 			zap.Error(err),
 			zap.String("path", path),
-			zap.String("bucket", s.bucket),
+			zap.String("bucket", bucket.BucketName),
 			zap.String("key", key))
 		return err
 	}
 
+	observeFileBytes(backendOss, directionDownload, path)
+
 	if s.debug {
-		zap.L().Debug("download file from aliyun oss bucket success",
+		ctx.L().Debug("download file from aliyun oss bucket success",
 			zap.String("path", path),
-			zap.String("bucket", s.bucket),
+			zap.String("bucket", bucket.BucketName),
 			zap.String("key", key))
 	}
 
 	return nil
 }
+
+// openOssBucket open bucket, endpoint and bucket are expanded from context
+// while credentials are only resolved by expandSecret
+func openOssBucket(ctx *Context, endPoint, keyID, keySecret, bucketName string) (*oss.Bucket, error) {
+	endPoint, err := ctx.Expand(endPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	bucketName, err = ctx.Expand(bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, err = expandSecret(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	keySecret, err = expandSecret(keySecret)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := oss.New(endPoint, keyID, keySecret)
+	if err != nil {
+		ctx.L().Error("create new aliyun oss client failed",
+			zap.Error(err),
+			zap.String("endpoint", endPoint),
+			zap.String("accessKeyID", keyID))
+		return nil, err
+	}
+
+	bucket, err := client.Bucket(bucketName)
+	if err != nil {
+		ctx.L().Error("get aliyun oss bucket failed",
+			zap.Error(err),
+			zap.String("bucket", bucketName))
+		return nil, err
+	}
+
+	return bucket, nil
+}
@@ -0,0 +1,147 @@
+package jobs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// maxTailLine longest line kept in output tails, longer ones are cut
+const maxTailLine = 1024
+
+// terminalMutex keeps prefixed lines of parallel commands whole on the shared terminal
+var terminalMutex sync.Mutex
+
+// CommandError command failed, with the last lines of its output
+type CommandError struct {
+	Err    error
+	Output string
+}
+
+// Error describe failure followed by output
+func (e *CommandError) Error() string {
+	if e.Output == "" {
+		return e.Err.Error()
+	}
+
+	return fmt.Sprintf("%v, last output:\n%s", e.Err, e.Output)
+}
+
+// Unwrap get failure of command
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// prefixWriter write whole lines to out, each starting with prefix
+type prefixWriter struct {
+	out     io.Writer
+	prefix  string
+	partial []byte
+}
+
+// Write write complete lines, keep the rest until its line ends
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+
+	end := bytes.LastIndexByte(w.partial, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+
+	buffer := new(bytes.Buffer)
+	for _, line := range bytes.SplitAfter(w.partial[:end+1], []byte("\n")) {
+		if len(line) > 0 {
+			buffer.WriteString(w.prefix)
+			buffer.Write(line)
+		}
+	}
+	w.partial = append(w.partial[:0], w.partial[end+1:]...)
+
+	terminalMutex.Lock()
+	defer terminalMutex.Unlock()
+
+	_, err := w.out.Write(buffer.Bytes())
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Flush write last line not ending with a newline
+func (w *prefixWriter) Flush() error {
+	if len(w.partial) == 0 {
+		return nil
+	}
+
+	_, err := w.Write([]byte("\n"))
+	return err
+}
+
+// outputTail last lines written by a command, stdout and stderr may write concurrently
+type outputTail struct {
+	max     int
+	lines   []string
+	partial []byte
+	mutex   sync.Mutex
+}
+
+// newOutputTail create tail keeping max lines
+func newOutputTail(max int) *outputTail {
+	return &outputTail{max: max}
+}
+
+// Write keep last lines
+func (t *outputTail) Write(p []byte) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, b := range p {
+		if b != '\n' {
+			if len(t.partial) < maxTailLine {
+				t.partial = append(t.partial, b)
+			}
+			continue
+		}
+
+		t.add(string(t.partial))
+		t.partial = t.partial[:0]
+	}
+
+	return len(p), nil
+}
+
+// add append line, dropping the oldest beyond max
+func (t *outputTail) add(line string) {
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+}
+
+// Reset forget output
+func (t *outputTail) Reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.lines = nil
+	t.partial = t.partial[:0]
+}
+
+// String get kept lines, the unfinished last line included
+func (t *outputTail) String() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	lines := t.lines
+	if len(t.partial) > 0 {
+		lines = append(lines[:len(lines):len(lines)], string(t.partial))
+		if len(lines) > t.max {
+			lines = lines[len(lines)-t.max:]
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
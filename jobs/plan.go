@@ -0,0 +1,118 @@
+package jobs
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Plan dry-run mode, actions with side effects print what they would do instead of running
+//
+// side-effect-free actions (range, match, replace, list, list_dir, exists) still run
+// to expand the job tree, fetch and render run only when enabled, and all branches run serially
+// so that the printed plan keeps the job tree order
+type Plan struct {
+	writer io.Writer
+	fetch  bool
+	mutex  sync.Mutex
+}
+
+// NewPlan create plan printing to writer, fetch enables running fetch and render actions
+func NewPlan(writer io.Writer, fetch bool) *Plan {
+	return &Plan{writer: writer, fetch: fetch}
+}
+
+// print print planned action
+func (p *Plan) print(depth int, name, text string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	fmt.Fprintf(p.writer, "%s[%s] %s\n", strings.Repeat("  ", depth), name, text)
+}
+
+// PlannedAction action with side effects which describes itself in plan mode
+type PlannedAction interface {
+	Plan(*Context) (string, error)
+}
+
+// SetPlan enable plan mode
+func (c *Context) SetPlan(plan *Plan) {
+	c.plan = plan
+}
+
+// planned check whether job is planned instead of executed
+func (s Job) planned(ctx *Context) (PlannedAction, bool) {
+	if ctx.plan == nil {
+		return nil, false
+	}
+
+	action, ok := s.Action.(PlannedAction)
+	if !ok {
+		return nil, false
+	}
+
+	switch s.Action.(type) {
+	case *Fetch, *Render:
+		if ctx.plan.fetch {
+			return nil, false
+		}
+	}
+
+	return action, true
+}
+
+// executePlan print planned action, then plan sub jobs as if it succeeded
+func (s Job) executePlan(ctx *Context, action PlannedAction) error {
+	text, err := action.Plan(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx.plan.print(ctx.depth, s.Name, text)
+
+	// contexts of multiple context actions are unknown until they run
+	if _, ok := s.Action.(MultipleContextAction); ok {
+		return nil
+	}
+
+	for _, job := range s.Jobs {
+		err = job.Execute(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// describe describe values set on context itself
+func (c *Context) describe() string {
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for index, key := range keys {
+		pairs[index] = key + "=" + formatValue(c.values[key])
+	}
+
+	return strings.Join(pairs, " ")
+}
+
+// shellQuote quote command line for printing
+func shellQuote(command string, args []string) string {
+	parts := make([]string, 0, len(args)+1)
+	for _, arg := range append([]string{command}, args...) {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`|&;<>()*?") {
+			arg = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
+
+		parts = append(parts, arg)
+	}
+
+	return strings.Join(parts, " ")
+}
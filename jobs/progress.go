@@ -0,0 +1,164 @@
+package jobs
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxNodeErrors distinct error messages counted per job node, later ones only count as failed
+const maxNodeErrors = 20
+
+// Progress per job node counters of a run
+//
+// a node is one action of the job tree, it may run many times, once for
+// every context its parent produces
+type Progress struct {
+	start time.Time
+	nodes map[interface{}]*nodeCounter
+	order []*nodeCounter
+}
+
+// nodeCounter counters of one job node, updated atomically
+type nodeCounter struct {
+	path      string
+	action    string
+	depth     int
+	started   int64
+	completed int64
+	failed    int64
+	produced  int64
+	done      int64
+	nanos     int64
+	lastError atomic.Value
+	errors    map[string]int64
+	mutex     sync.Mutex
+}
+
+// NodeProgress job node counters
+type NodeProgress struct {
+	// Path dotted path of the node in the job tree, e.g. range.fetch.execute
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	Depth  int    `json:"depth"`
+	// Started Completed Failed executions of the node, a failure of a child job
+	// only counts as failed at the child
+	Started   int64 `json:"started"`
+	Completed int64 `json:"completed"`
+	Failed    int64 `json:"failed"`
+	// Produced contexts produced for child jobs, Done those whose child jobs finished
+	Produced int64 `json:"produced"`
+	Done     int64 `json:"done"`
+	// Duration total time spent in executions of the node and its child jobs
+	Duration  time.Duration `json:"duration"`
+	LastError string        `json:"last_error,omitempty"`
+}
+
+// NewProgress create progress of job tree
+func NewProgress(jobs []*Job) *Progress {
+	p := &Progress{start: time.Now(), nodes: make(map[interface{}]*nodeCounter)}
+	p.register(0, jobs)
+
+	return p
+}
+
+// register add nodes of jobs in tree order
+func (p *Progress) register(depth int, jobs []*Job) {
+	for _, job := range jobs {
+		if _, found := p.nodes[job.Action]; found {
+			continue
+		}
+
+		node := &nodeCounter{path: job.Path, action: job.Name, depth: depth, errors: make(map[string]int64)}
+		p.nodes[job.Action] = node
+		p.order = append(p.order, node)
+
+		p.register(depth+1, job.Jobs)
+		p.register(depth+1, job.ElseJobs)
+	}
+}
+
+// node get counters of job, nil for unknown jobs
+func (p *Progress) node(job Job) *nodeCounter {
+	if p == nil {
+		return nil
+	}
+
+	return p.nodes[job.Action]
+}
+
+// Nodes get counters of every node in tree order
+func (p *Progress) Nodes() []NodeProgress {
+	nodes := make([]NodeProgress, len(p.order))
+	for index, node := range p.order {
+		nodes[index] = NodeProgress{
+			Path:      node.path,
+			Action:    node.action,
+			Depth:     node.depth,
+			Started:   atomic.LoadInt64(&node.started),
+			Completed: atomic.LoadInt64(&node.completed),
+			Failed:    atomic.LoadInt64(&node.failed),
+			Produced:  atomic.LoadInt64(&node.produced),
+			Done:      atomic.LoadInt64(&node.done),
+			Duration:  time.Duration(atomic.LoadInt64(&node.nanos)),
+		}
+
+		if err, ok := node.lastError.Load().(string); ok {
+			nodes[index].LastError = err
+		}
+	}
+
+	return nodes
+}
+
+// Elapsed get time since progress created
+func (p *Progress) Elapsed() time.Duration {
+	return time.Since(p.start)
+}
+
+// SetProgress count executions of job nodes
+func (c *Context) SetProgress(progress *Progress) {
+	c.progress = progress
+}
+
+func (n *nodeCounter) begin() time.Time {
+	if n != nil {
+		atomic.AddInt64(&n.started, 1)
+	}
+
+	return time.Now()
+}
+
+func (n *nodeCounter) end(start time.Time, err error) {
+	if n == nil {
+		return
+	}
+
+	atomic.AddInt64(&n.nanos, int64(time.Since(start)))
+	if err == nil || isRecorded(err) {
+		atomic.AddInt64(&n.completed, 1)
+		return
+	}
+
+	atomic.AddInt64(&n.failed, 1)
+	n.lastError.Store(err.Error())
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if _, found := n.errors[err.Error()]; found || len(n.errors) < maxNodeErrors {
+		n.errors[err.Error()]++
+	}
+}
+
+func (n *nodeCounter) produce() {
+	if n != nil {
+		atomic.AddInt64(&n.produced, 1)
+	}
+}
+
+func (n *nodeCounter) branchDone() {
+	if n != nil {
+		atomic.AddInt64(&n.done, 1)
+	}
+}
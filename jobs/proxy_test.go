@@ -0,0 +1,229 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// proxyStandIn http proxy answering every request itself with its name,
+// or with status when it is not 200
+type proxyStandIn struct {
+	server *httptest.Server
+	hits   int
+	mutex  sync.Mutex
+}
+
+// newProxyStandIn start proxy stand-in
+func newProxyStandIn(name string, status int) *proxyStandIn {
+	p := new(proxyStandIn)
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mutex.Lock()
+		p.hits++
+		p.mutex.Unlock()
+
+		// requests through a proxy carry the absolute url of the target
+		if !r.URL.IsAbs() {
+			http.Error(w, "not a proxy request", http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "<a>%s</a>", name)
+	}))
+
+	return p
+}
+
+// count get requests received so far
+func (p *proxyStandIn) count() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.hits
+}
+
+// newProxyFetch create fetch action of url through proxy pool, capturing the name of the proxy,
+// a nil pool leaves the proxy option unset
+func newProxyFetch(t *testing.T, url string, pool Config) *Fetch {
+	c := Config{retryPolicyKey: map[string]interface{}{"retry": int64(3), "interval": "1ms", "jitter": 0.0}}
+	config := Config{"url": url, "regexp": "<a>(\\w+)</a>", "sets": []interface{}{"proxy"}}
+	if pool != nil {
+		c[proxiesKey] = map[string]interface{}{"test": map[string]interface{}(pool)}
+		config["proxy"] = "test"
+	}
+
+	resources, err := readResources(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := (&Config{}).toJob("fetch", &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = setResources([]*Job{job}, resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return job.Action.(*Fetch)
+}
+
+// fetchProxy fetch once and get the name of the proxy which answered
+func fetchProxy(t *testing.T, action *Fetch) string {
+	ctxs, err := action.Do(NewContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ctxs) != 1 {
+		t.Fatalf("got %d matches, expect 1", len(ctxs))
+	}
+
+	name, err := ctxs[0].String("proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return name
+}
+
+func TestProxyRoundRobin(t *testing.T) {
+	first, second := newProxyStandIn("first", http.StatusOK), newProxyStandIn("second", http.StatusOK)
+	defer first.server.Close()
+	defer second.server.Close()
+
+	action := newProxyFetch(t, "http://site.test/", Config{"urls": []interface{}{first.server.URL, second.server.URL}})
+
+	var names []string
+	for index := 0; index < 4; index++ {
+		names = append(names, fetchProxy(t, action))
+	}
+
+	if fmt.Sprint(names) != "[first second first second]" {
+		t.Errorf("got proxies %v, expect each in turn", names)
+	}
+}
+
+func TestProxySticky(t *testing.T) {
+	first, second := newProxyStandIn("first", http.StatusOK), newProxyStandIn("second", http.StatusOK)
+	defer first.server.Close()
+	defer second.server.Close()
+
+	pool := Config{"urls": []interface{}{first.server.URL, second.server.URL}, "rotation": rotationSticky}
+	siteA := newProxyFetch(t, "http://a.test/", pool)
+	// both actions share the pool of their job file
+	siteB := *siteA
+	siteB.url = "http://b.test/"
+
+	// round robin would alternate between the proxies whatever the host
+	var names []string
+	for _, action := range []*Fetch{siteA, siteA, &siteB, &siteB, siteA, &siteB} {
+		names = append(names, fetchProxy(t, action))
+	}
+
+	if fmt.Sprint(names) != "[first first second second first second]" {
+		t.Errorf("got proxies %v, expect every host to keep its proxy", names)
+	}
+}
+
+func TestProxyEjection(t *testing.T) {
+	unauthorized, working := newProxyStandIn("unauthorized", http.StatusProxyAuthRequired), newProxyStandIn("working", http.StatusOK)
+	defer unauthorized.server.Close()
+	defer working.server.Close()
+
+	// nothing listens on the url of a closed server
+	closed := newProxyStandIn("closed", http.StatusOK)
+	closed.server.Close()
+
+	for _, failing := range []*proxyStandIn{unauthorized, closed} {
+		pool := Config{"urls": []interface{}{failing.server.URL, working.server.URL}, "max_failures": int64(2), "eject": "1m"}
+		action := newProxyFetch(t, "http://site.test/", pool)
+		ejections := proxyEjectionsTotal.WithLabelValues("test")
+		before := testutil.ToFloat64(ejections)
+
+		// every fetch succeeds, failed attempts are retried through the next proxy
+		for index := 0; index < 6; index++ {
+			if name := fetchProxy(t, action); name != "working" {
+				t.Fatalf("fetch %d: got proxy %s, expect working", index, name)
+			}
+		}
+
+		// failed twice, then ejected for the rest of the fetches
+		if hits := unauthorized.count(); failing == unauthorized && hits != 2 {
+			t.Errorf("got %d requests through the unauthorized proxy, expect 2", hits)
+		}
+
+		if got := testutil.ToFloat64(ejections) - before; got != 1 {
+			t.Errorf("%s proxy: got %v ejections, expect 1", failing.server.URL, got)
+		}
+	}
+}
+
+func TestProxyTargetStatus(t *testing.T) {
+	forbidden := newProxyStandIn("forbidden", http.StatusForbidden)
+	defer forbidden.server.Close()
+
+	pool := Config{"urls": []interface{}{forbidden.server.URL}, "max_failures": int64(1)}
+	action := newProxyFetch(t, "http://site.test/", pool)
+	ejections := proxyEjectionsTotal.WithLabelValues("test")
+	before := testutil.ToFloat64(ejections)
+
+	// the target refusing the request says nothing about the proxy
+	for index := 0; index < 3; index++ {
+		_, err := action.Do(NewContext())
+		var status *StatusError
+		if !errors.As(err, &status) || status.StatusCode != http.StatusForbidden {
+			t.Fatalf("fetch %d: got error %v, expect status %d", index, err, http.StatusForbidden)
+		}
+	}
+
+	if hits := forbidden.count(); hits != 3 {
+		t.Errorf("got %d requests, expect 3 without retries", hits)
+	}
+
+	if got := testutil.ToFloat64(ejections) - before; got != 0 {
+		t.Errorf("got %v ejections, expect none", got)
+	}
+}
+
+func TestGlobalProxies(t *testing.T) {
+	global := newProxyStandIn("global", http.StatusOK)
+	defer global.server.Close()
+
+	err := SetProxies([]string{global.server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer SetProxies(nil)
+
+	// actions without proxy option of job files without a default pool use the global proxies
+	if name := fetchProxy(t, newProxyFetch(t, "http://site.test/", nil)); name != "global" {
+		t.Errorf("without pool: got proxy %s, expect global", name)
+	}
+
+	// a default pool of the job file and direct take precedence
+	pool, err := newProxyPool(defaultProxyPool, Config{"urls": []interface{}{"http://10.0.0.1:8080"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := proxyPoolOf("", map[string]*proxyPool{defaultProxyPool: pool}); got != pool {
+		t.Errorf("with default pool: got pool %v, expect the default pool", got)
+	}
+
+	if got, _ := proxyPoolOf(proxyDirect, nil); got != nil {
+		t.Errorf("direct: got pool %v, expect none", got)
+	}
+
+	if err = SetProxies([]string{"ftp://10.0.0.1"}); err == nil {
+		t.Error("ftp proxy: got no error")
+	}
+}
@@ -15,10 +15,12 @@ var (
 
 // Range for range
 type Range struct {
-	start string
-	end   string
-	set   string
-	debug bool
+	start    string
+	end      string
+	set      string
+	parallel int
+	debug    bool
+	ordering
 }
 
 // newRange create range action
@@ -41,42 +43,79 @@ func newRange(c *Config) (interface{}, error) {
 		return nil, err
 	}
 
+	parallel := c.IntDefault("parallel", 0)
+
+	ordering, err := newOrdering(c)
+	if err != nil {
+		return nil, err
+	}
+
 	debug := c.BoolDefault("debug", false)
 
 	return &Range{
-		start: start,
-		end:   end,
-		set:   set,
-		debug: debug,
+		start:    start,
+		end:      end,
+		set:      set,
+		parallel: parallel,
+		debug:    debug,
+		ordering: ordering,
 	}, nil
 }
 
+// Parallel get max concurrent branches
+func (s Range) Parallel() int {
+	return s.parallel
+}
+
+// Attributes describe expanded options for tracing
+func (s Range) Attributes(ctx *Context) map[string]string {
+	return map[string]string{"expression": expandAttribute(ctx, s.start) + "-" + expandAttribute(ctx, s.end)}
+}
+
 // Do do job
 func (s Range) Do(ctx *Context) ([]*Context, error) {
-	start, err := strconv.Atoi(ctx.Expand(s.start))
+	return collectContexts(ctx, s.Stream)
+}
+
+// Stream yield context for each number in range
+func (s Range) Stream(ctx *Context, yield func(*Context) error) error {
+	startExpression, err := ctx.Expand(s.start)
 	if err != nil {
-		return nil, ErrInvalidRangeExpression
+		return err
 	}
 
-	end, err := strconv.Atoi(ctx.Expand(s.end))
+	endExpression, err := ctx.Expand(s.end)
 	if err != nil {
-		return nil, ErrInvalidRangeExpression
+		return err
+	}
+
+	start, err := strconv.Atoi(startExpression)
+	if err != nil {
+		return ErrInvalidRangeExpression
+	}
+
+	end, err := strconv.Atoi(endExpression)
+	if err != nil {
+		return ErrInvalidRangeExpression
 	}
 
 	if start > end {
-		return nil, nil
+		return nil
 	}
 
 	if s.debug {
-		zap.L().Debug("range expand success", zap.Int("start", start), zap.Int("end", end))
+		ctx.L().Debug("range expand success", zap.Int("start", start), zap.Int("end", end))
 	}
 
-	ctxs := make([]*Context, 0, end-start+1)
 	for index := start; index <= end; index++ {
 		cloneCtx := ctx.Clone()
-		cloneCtx.Set(s.set, strconv.Itoa(index))
-		ctxs = append(ctxs, cloneCtx)
+		cloneCtx.SetValue(s.set, index)
+
+		err = yield(cloneCtx)
+		if err != nil {
+			return err
+		}
 	}
 
-	return ctxs, nil
+	return nil
 }
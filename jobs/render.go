@@ -0,0 +1,186 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/nzai/crawl/constants"
+	"go.uber.org/zap"
+)
+
+// Render load page in headless chromium and match regexp against the rendered html
+type Render struct {
+	url      string
+	browser  string
+	headers  map[string]string
+	wait     string
+	idle     time.Duration
+	timeout  time.Duration
+	regexp   *regexp.Regexp
+	sets     []string
+	parallel int
+	debug    bool
+	ordering
+	retrying
+}
+
+// newRender create render action
+func newRender(c *Config) (interface{}, error) {
+	url, err := c.String("url")
+	if err != nil {
+		return nil, err
+	}
+
+	expression, err := c.String("regexp")
+	if err != nil {
+		return nil, err
+	}
+
+	regex, err := regexp.Compile(expression)
+	if err != nil {
+		zap.L().Error("compile regex expression failed",
+			zap.Error(err),
+			zap.String("expression", expression))
+		return nil, err
+	}
+
+	sets, err := c.Strings("sets")
+	if err != nil {
+		return nil, err
+	}
+
+	ordering, err := newOrdering(c)
+	if err != nil {
+		return nil, err
+	}
+
+	retrying, err := newRetrying(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Render{
+		url:      url,
+		browser:  c.StringDefault("browser", ""),
+		headers:  c.MapDefault("headers"),
+		wait:     c.StringDefault("wait", ""),
+		idle:     c.DurationDefault("idle", constants.DefaultNetworkIdle),
+		timeout:  c.DurationDefault("timeout", constants.DefaultRenderTimeout),
+		regexp:   regex,
+		sets:     sets,
+		parallel: c.IntDefault("parallel", 0),
+		debug:    c.BoolDefault("debug", false),
+		ordering: ordering,
+		retrying: retrying,
+	}, nil
+}
+
+// Parallel get max concurrent branches
+func (s Render) Parallel() int {
+	return s.parallel
+}
+
+// Plan describe page load in plan mode
+func (s Render) Plan(ctx *Context) (string, error) {
+	url, err := ctx.Expand(s.url)
+	if err != nil {
+		return "", err
+	}
+
+	if s.wait != "" {
+		return "RENDER " + url + " until " + s.wait, nil
+	}
+
+	return "RENDER " + url, nil
+}
+
+// Attributes describe expanded options for tracing
+func (s Render) Attributes(ctx *Context) map[string]string {
+	return map[string]string{"url": expandAttribute(ctx, s.url), "wait": s.wait}
+}
+
+// Do do job
+func (s Render) Do(ctx *Context) ([]*Context, error) {
+	html, err := s.getHTML(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return matchHTML(ctx, s.regexp, s.sets, html, s.debug)
+}
+
+func (s Render) getHTML(ctx *Context) (string, error) {
+	rawURL, err := ctx.Expand(s.url)
+	if err != nil {
+		return "", err
+	}
+
+	options := renderOptions{headers: make(map[string]string, len(s.headers)), wait: s.wait, idle: s.idle}
+	for key, value := range s.headers {
+		header, err := ctx.Expand(value)
+		if err != nil {
+			return "", err
+		}
+
+		options.headers[key] = header
+	}
+
+	b, err := getBrowser(s.browser)
+	if err != nil {
+		ctx.L().Error("get browser failed", zap.Error(err), zap.String("browser", s.browser))
+		return "", err
+	}
+
+	host := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Host
+	}
+
+	var html string
+	attempts := 0
+	attempt := func() error {
+		attempts++
+		if attempts > 1 {
+			// the browser may have crashed, get a new one
+			b, err = getBrowser(s.browser)
+			if err != nil {
+				return err
+			}
+		}
+
+		span := ctx.startSpan("render "+host, "url", rawURL, "attempt", strconv.Itoa(attempts))
+		done, cancel := context.WithTimeout(ctx.done(), s.timeout)
+		html, err = b.render(done, rawURL, options)
+		cancel()
+		span.End(err)
+
+		return err
+	}
+
+	// page loads fail for many reasons a later attempt may not meet
+	retryable := func(err error) bool {
+		var status *StatusError
+		if errors.As(err, &status) {
+			return s.retry.retryable(err)
+		}
+
+		return !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrBrowserNotFound)
+	}
+
+	err = s.retry.run(ctx, "render", attempt, retryable, zap.String("url", rawURL))
+	if err != nil {
+		ctx.L().Error("render html failed", zap.Error(err), zap.String("url", rawURL))
+		return "", err
+	}
+
+	bytesTotal.WithLabelValues(backendBrowser, directionDownload).Add(float64(len(html)))
+	if s.debug {
+		ctx.L().Debug("render html success", zap.String("url", rawURL), zap.Int("size", len(html)))
+	}
+
+	return html, nil
+}
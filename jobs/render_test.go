@@ -0,0 +1,146 @@
+package jobs
+
+import (
+	"errors"
+	"net/http"
+	"os/exec"
+	"testing"
+)
+
+// newTestRender create render action of url on browser, without retries
+func newTestRender(t *testing.T, url, browser string, options Config) *Render {
+	config := Config{
+		"url":     url,
+		"browser": browser,
+		"regexp":  "<a>(\\d)</a>",
+		"sets":    []interface{}{"id"},
+		"idle":    "10ms",
+		"timeout": "10s",
+		"retry":   int64(0),
+	}
+	for key, value := range options {
+		config[key] = value
+	}
+
+	action, err := newRender(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return action.(*Render)
+}
+
+// renderIDs render page and get the matched ids
+func renderIDs(action *Render, ctx *Context) ([]string, error) {
+	ctxs, err := action.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(ctxs))
+	for index, c := range ctxs {
+		ids[index], err = c.String("id")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ids, nil
+}
+
+func TestRender(t *testing.T) {
+	tools := newDevTools()
+	defer tools.server.Close()
+	defer CloseBrowsers()
+
+	static := newStaticServer()
+	defer static.Close()
+
+	ctx := NewContext()
+	ctx.Set("token", "secret")
+
+	tests := []struct {
+		name    string
+		options Config
+	}{
+		{"network idle", nil},
+		{"wait for selector", Config{"wait": "#ready"}},
+		// the page only matches when the expanded header reached the server
+		{"expanded headers", Config{"headers": map[string]interface{}{"X-Token": "${token}"}, "regexp": `<a>(\d)</a>.*>(secret)<`, "sets": []interface{}{"id", "token"}}},
+	}
+
+	for _, test := range tests {
+		ids, err := renderIDs(newTestRender(t, static.URL+"/", tools.server.URL, test.options), ctx)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if len(ids) == 0 {
+			t.Errorf("%s: got no match", test.name)
+		}
+	}
+
+	ids, err := renderIDs(newTestRender(t, static.URL+"/", tools.server.URL, nil), ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 2 || ids[0] != "1" || ids[1] != "2" {
+		t.Errorf("got ids %v, expect [1 2]", ids)
+	}
+}
+
+func TestRenderStatus(t *testing.T) {
+	tools := newDevTools()
+	defer tools.server.Close()
+	defer CloseBrowsers()
+
+	static := newStaticServer()
+	defer static.Close()
+
+	_, err := renderIDs(newTestRender(t, static.URL+"/missing", tools.server.URL, nil), NewContext())
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("render missing page: got %v, expect %v", err, ErrNotFound)
+	}
+
+	_, err = renderIDs(newTestRender(t, static.URL+"/broken", tools.server.URL, nil), NewContext())
+	var status *StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusInternalServerError {
+		t.Errorf("render broken page: got %v, expect status %d", err, http.StatusInternalServerError)
+	}
+
+	// a selector which never matches waits until the timeout
+	_, err = renderIDs(newTestRender(t, static.URL+"/", tools.server.URL, Config{"wait": "#absent", "timeout": "100ms"}), NewContext())
+	if err == nil {
+		t.Error("render waiting for absent selector: got no error")
+	}
+}
+
+// TestRenderChromium render a static page in a real chromium, skipped without one in PATH
+func TestRenderChromium(t *testing.T) {
+	found := false
+	for _, name := range browserNames {
+		if _, err := exec.LookPath(name); err == nil {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		t.Skip(ErrBrowserNotFound)
+	}
+	defer CloseBrowsers()
+
+	static := newStaticServer()
+	defer static.Close()
+
+	ids, err := renderIDs(newTestRender(t, static.URL+"/", "", Config{"wait": "#ready"}), NewContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 2 {
+		t.Errorf("got ids %v, expect [1 2]", ids)
+	}
+}
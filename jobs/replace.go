@@ -50,13 +50,17 @@ func newReplace(c *Config) (interface{}, error) {
 
 // Do do job
 func (s Replace) Do(ctx *Context) error {
-	expression := ctx.Expand(s.expression)
+	expression, err := ctx.Expand(s.expression)
+	if err != nil {
+		return err
+	}
+
 	newExpression := strings.Replace(expression, s.old, s.new, -1)
 
 	ctx.Set(s.set, newExpression)
 
 	if s.debug {
-		zap.L().Debug("replaced",
+		ctx.L().Debug("replaced",
 			zap.String("expression", expression),
 			zap.String("newExpression", newExpression),
 			zap.String("key", s.set))
@@ -0,0 +1,80 @@
+package jobs
+
+import (
+	"sort"
+	"time"
+)
+
+// Report summary of a finished run
+type Report struct {
+	Duration time.Duration  `json:"duration"`
+	Nodes    []NodeProgress `json:"nodes"`
+	Actions  []ActionReport `json:"actions"`
+}
+
+// ActionReport counters of every node of an action summed up
+type ActionReport struct {
+	Action    string        `json:"action"`
+	Started   int64         `json:"started"`
+	Completed int64         `json:"completed"`
+	Failed    int64         `json:"failed"`
+	Duration  time.Duration `json:"duration"`
+	// Errors distinct error messages with their counts, most frequent first
+	Errors []ErrorCount `json:"errors,omitempty"`
+}
+
+// ErrorCount occurrences of an error message
+type ErrorCount struct {
+	Error string `json:"error"`
+	Count int64  `json:"count"`
+}
+
+// Report summarize progress, counts are read at the time of the call
+func (p *Progress) Report() Report {
+	report := Report{Duration: p.Elapsed(), Nodes: p.Nodes()}
+
+	actions := make(map[string]*ActionReport)
+	errors := make(map[string]map[string]int64)
+	for index, node := range p.order {
+		progress := report.Nodes[index]
+
+		action, found := actions[node.action]
+		if !found {
+			action = &ActionReport{Action: node.action}
+			actions[node.action] = action
+			errors[node.action] = make(map[string]int64)
+		}
+
+		action.Started += progress.Started
+		action.Completed += progress.Completed
+		action.Failed += progress.Failed
+		action.Duration += progress.Duration
+
+		node.mutex.Lock()
+		for message, count := range node.errors {
+			errors[node.action][message] += count
+		}
+		node.mutex.Unlock()
+	}
+
+	for name, action := range actions {
+		for message, count := range errors[name] {
+			action.Errors = append(action.Errors, ErrorCount{Error: message, Count: count})
+		}
+
+		sort.Slice(action.Errors, func(i, j int) bool {
+			if action.Errors[i].Count != action.Errors[j].Count {
+				return action.Errors[i].Count > action.Errors[j].Count
+			}
+			return action.Errors[i].Error < action.Errors[j].Error
+		})
+
+		report.Actions = append(report.Actions, *action)
+	}
+
+	sort.Slice(report.Actions, func(i, j int) bool {
+		return report.Actions[i].Action < report.Actions[j].Action
+	})
+
+	return report
+}
@@ -0,0 +1,69 @@
+package jobs
+
+import (
+	"fmt"
+	"testing"
+)
+
+// failPage single context action failing on one page
+type failPage struct {
+	page string
+}
+
+// Do do job
+func (f *failPage) Do(ctx *Context) error {
+	page, err := ctx.String("page")
+	if err != nil {
+		return err
+	}
+
+	if page == f.page {
+		return fmt.Errorf("page %s failed", page)
+	}
+
+	return nil
+}
+
+func TestReportNestedFailure(t *testing.T) {
+	jobs := []*Job{{Name: "range", Action: &Range{start: "1", end: "2", set: "group"}, Jobs: []*Job{
+		{Name: "range", Action: &Range{start: "1", end: "3", set: "page"}, Jobs: []*Job{
+			{Name: "check", Action: &failPage{page: "2"}},
+		}},
+	}}}
+	setPaths("", jobs)
+
+	progress := NewProgress(jobs)
+	ctx := NewContext()
+	ctx.SetProgress(progress)
+
+	err := jobs[0].Execute(ctx)
+	if err == nil || err.Error() != "page 2 failed" {
+		t.Fatalf("got error %v, expect page 2 failed", err)
+	}
+
+	report := progress.Report()
+	for _, node := range report.Nodes {
+		failed, lastError := int64(0), ""
+		if node.Action == "check" {
+			failed, lastError = 1, "page 2 failed"
+		}
+
+		if node.Failed != failed || node.LastError != lastError {
+			t.Errorf("node %s: got %d failed with error %q, expect %d with %q", node.Path, node.Failed, node.LastError, failed, lastError)
+		}
+	}
+
+	for _, action := range report.Actions {
+		switch action.Action {
+		case "check":
+			if action.Failed != 1 || len(action.Errors) != 1 || action.Errors[0] != (ErrorCount{Error: "page 2 failed", Count: 1}) {
+				t.Errorf("action check: got %d failed with errors %v, expect the failure once", action.Failed, action.Errors)
+			}
+		case "range":
+			// the failure of the child is not counted again at every ancestor
+			if action.Failed != 0 || len(action.Errors) != 0 || action.Completed != action.Started {
+				t.Errorf("action range: got %d started %d completed %d failed with errors %v, expect no failure", action.Started, action.Completed, action.Failed, action.Errors)
+			}
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package jobs
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/nzai/crawl/constants"
+	"github.com/tencentyun/cos-go-sdk-v5"
+	"go.uber.org/zap"
+)
+
+// retryPolicyKey top-level table of retry options every action defaults to
+const retryPolicyKey = "retry_policy"
+
+// retryPolicy retry with exponential backoff and jitter
+//
+//	[retry_policy]
+//	retry = 5
+//	interval = "10s"
+//	max_interval = "1m"
+//	multiplier = 2.0
+//	jitter = 0.2
+//	max_elapsed = "5m"
+//	retry_exit_codes = [75]
+type retryPolicy struct {
+	// retries retries after the first attempt
+	retries     int
+	interval    time.Duration
+	maxInterval time.Duration
+	multiplier  float64
+	// jitter fraction of the wait randomized in both directions
+	jitter float64
+	// maxElapsed time after which no more retries start, 0 is unlimited
+	maxElapsed time.Duration
+	// exitCodes exit codes of execute worth retrying
+	exitCodes map[int]bool
+}
+
+// defaultRetryPolicy policy of job files without retry policy table
+var defaultRetryPolicy = retryPolicy{
+	retries:     constants.DefaultRetry,
+	interval:    constants.DefaultRetryInterval,
+	maxInterval: constants.DefaultRetryMaxInterval,
+	multiplier:  constants.DefaultRetryMultiplier,
+	jitter:      constants.DefaultRetryJitter,
+}
+
+// throttlingCodes oss and cos error codes of throttled or temporarily failing requests
+var throttlingCodes = map[string]bool{
+	"RequestTimeout":                   true,
+	"InternalError":                    true,
+	"ServiceUnavailable":               true,
+	"SlowDown":                         true,
+	"Throttling":                       true,
+	"QpsLimitExceeded":                 true,
+	"DownloadTrafficRateLimitExceeded": true,
+	"UploadTrafficRateLimitExceeded":   true,
+}
+
+// readRetryPolicy remove retry policy table from config and create its policy
+func readRetryPolicy(c Config) (retryPolicy, error) {
+	value, found := c[retryPolicyKey]
+	if !found {
+		return defaultRetryPolicy, nil
+	}
+	delete(c, retryPolicyKey)
+
+	table, ok := value.(map[string]interface{})
+	if !ok {
+		return retryPolicy{}, fmt.Errorf("%s: expect a table", retryPolicyKey)
+	}
+
+	policy, err := newRetryPolicy(Config(table), defaultRetryPolicy)
+	if err != nil {
+		return retryPolicy{}, fmt.Errorf("%s: %w", retryPolicyKey, err)
+	}
+
+	return policy, nil
+}
+
+// newRetryPolicy create policy from config, unset options keep defaults
+func newRetryPolicy(c Config, defaults retryPolicy) (retryPolicy, error) {
+	policy := retryPolicy{
+		retries:     c.IntDefault("retry", defaults.retries),
+		interval:    c.DurationDefault("interval", defaults.interval),
+		maxInterval: c.DurationDefault("max_interval", defaults.maxInterval),
+		multiplier:  c.FloatDefault("multiplier", defaults.multiplier),
+		jitter:      c.FloatDefault("jitter", defaults.jitter),
+		maxElapsed:  c.DurationDefault("max_elapsed", defaults.maxElapsed),
+		exitCodes:   defaults.exitCodes,
+	}
+
+	if _, found := c["retry_exit_codes"]; found {
+		codes, err := c.Ints("retry_exit_codes")
+		if err != nil {
+			return policy, err
+		}
+
+		policy.exitCodes = make(map[int]bool, len(codes))
+		for _, code := range codes {
+			policy.exitCodes[code] = true
+		}
+	}
+
+	if policy.retries < 0 {
+		return policy, fmt.Errorf("invalid retry %d, expect 0 or more", policy.retries)
+	}
+
+	if policy.multiplier < 1 {
+		return policy, fmt.Errorf("invalid multiplier %g, expect 1 or more", policy.multiplier)
+	}
+
+	if policy.jitter < 0 || policy.jitter > 1 {
+		return policy, fmt.Errorf("invalid jitter %g, expect 0 to 1", policy.jitter)
+	}
+
+	return policy, nil
+}
+
+// backoff wait before retry index, growing from interval by multiplier up to max interval
+func (p retryPolicy) backoff(index int) time.Duration {
+	limit := p.maxInterval
+	if limit < p.interval {
+		limit = p.interval
+	}
+
+	wait := float64(p.interval) * math.Pow(p.multiplier, float64(index))
+	if wait > float64(limit) {
+		wait = float64(limit)
+	}
+
+	wait *= 1 + p.jitter*(2*rand.Float64()-1)
+	return time.Duration(wait)
+}
+
+// run call attempt until it succeeds, fails with an error not worth
+// retrying, runs out of retries or would wait past max elapsed time,
+// retryable nil classifies errors with the policy itself
+func (p retryPolicy) run(ctx *Context, action string, attempt func() error, retryable func(error) bool, fields ...zap.Field) error {
+	if retryable == nil {
+		retryable = p.retryable
+	}
+
+	start := time.Now()
+	for index := 0; ; index++ {
+		err := attempt()
+		if err == nil || ctx.Err() != nil || index >= p.retries || !retryable(err) {
+			return err
+		}
+
+		wait := p.backoff(index)
+		if p.maxElapsed > 0 && time.Since(start)+wait > p.maxElapsed {
+			return err
+		}
+
+		retriesTotal.WithLabelValues(action).Inc()
+		ctx.L().Warn(action+" failed, retry later", append([]zap.Field{
+			zap.Error(err),
+			zap.Duration("wait", wait),
+			zap.Int("remain", p.retries-index)}, fields...)...)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.done().Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// retryable check whether error is worth another attempt: network errors,
+// timeouts, 408, 429 and 5xx statuses, oss and cos throttling, and exit
+// codes of the policy
+func (p retryPolicy) retryable(err error) bool {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return retryableStatus(status.StatusCode)
+	}
+
+	var ossError oss.ServiceError
+	if errors.As(err, &ossError) {
+		return retryableStatus(ossError.StatusCode) || throttlingCodes[ossError.Code]
+	}
+
+	var ossStatus oss.UnexpectedStatusCodeError
+	if errors.As(err, &ossStatus) {
+		return retryableStatus(ossStatus.Got())
+	}
+
+	var cosError *cos.ErrorResponse
+	if errors.As(err, &cosError) {
+		return throttlingCodes[cosError.Code] || cosError.Response != nil && retryableStatus(cosError.Response.StatusCode)
+	}
+
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) {
+		return p.exitCodes[exitError.ExitCode()]
+	}
+
+	// url errors are net errors themselves, look at the cause
+	var urlError *url.Error
+	if errors.As(err, &urlError) {
+		err = urlError.Err
+	}
+
+	var authorityError x509.UnknownAuthorityError
+	var certificateError x509.CertificateInvalidError
+	var hostnameError x509.HostnameError
+	if errors.As(err, &authorityError) || errors.As(err, &certificateError) || errors.As(err, &hostnameError) {
+		return false
+	}
+
+	var netError net.Error
+	if errors.As(err, &netError) {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// retryableStatus check whether response status is temporary
+func retryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// retrying retry options of an action, resolved against the retry policy
+// of its job file
+type retrying struct {
+	retryConfig Config
+	retry       retryPolicy
+}
+
+// newRetrying pick retry options of action config
+func newRetrying(c *Config) (retrying, error) {
+	options := make(Config)
+	for key := range retryOptions {
+		if value, found := (*c)[key]; found {
+			options[key] = value
+		}
+	}
+
+	if value, found := (*c)["retry_exit_codes"]; found {
+		options["retry_exit_codes"] = value
+	}
+
+	// check values now, so that errors point at the action
+	policy, err := newRetryPolicy(options, defaultRetryPolicy)
+	if err != nil {
+		return retrying{}, err
+	}
+
+	return retrying{retryConfig: options, retry: policy}, nil
+}
+
+// useResources apply retry options over the policy of the job file
+func (r *retrying) useResources(res *resources) error {
+	policy, err := newRetryPolicy(r.retryConfig, res.retry)
+	if err != nil {
+		return err
+	}
+
+	r.retry = policy
+	return nil
+}
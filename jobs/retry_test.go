@@ -0,0 +1,176 @@
+package jobs
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	policy := retryPolicy{interval: 10 * time.Millisecond, maxInterval: 50 * time.Millisecond, multiplier: 2}
+	tests := []struct {
+		policy retryPolicy
+		index  int
+		expect time.Duration
+	}{
+		// grows by multiplier
+		{policy, 0, 10 * time.Millisecond},
+		{policy, 1, 20 * time.Millisecond},
+		{policy, 2, 40 * time.Millisecond},
+		// capped at max interval
+		{policy, 3, 50 * time.Millisecond},
+		{policy, 10, 50 * time.Millisecond},
+		// a max interval below interval keeps the interval
+		{retryPolicy{interval: time.Second, maxInterval: time.Millisecond, multiplier: 2}, 3, time.Second},
+		// multiplier 1 waits the same every time
+		{retryPolicy{interval: time.Second, maxInterval: time.Minute, multiplier: 1}, 5, time.Second},
+	}
+
+	for _, test := range tests {
+		if wait := test.policy.backoff(test.index); wait != test.expect {
+			t.Errorf("backoff %d of %+v: got %v, expect %v", test.index, test.policy, wait, test.expect)
+		}
+	}
+}
+
+func TestRetryBackoffJitter(t *testing.T) {
+	policy := retryPolicy{interval: 100 * time.Millisecond, maxInterval: time.Second, multiplier: 2, jitter: 0.2}
+	tests := []struct {
+		index    int
+		min, max time.Duration
+	}{
+		{0, 80 * time.Millisecond, 120 * time.Millisecond},
+		{1, 160 * time.Millisecond, 240 * time.Millisecond},
+		// jitter applies to the capped wait
+		{5, 800 * time.Millisecond, 1200 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		varied := false
+		for sample := 0; sample < 1000; sample++ {
+			wait := policy.backoff(test.index)
+			if wait < test.min || wait > test.max {
+				t.Fatalf("backoff %d: got %v, expect %v to %v", test.index, wait, test.min, test.max)
+			}
+
+			varied = varied || wait != policy.backoff(test.index)
+		}
+
+		if !varied {
+			t.Errorf("backoff %d: got the same wait every time, expect jitter", test.index)
+		}
+	}
+}
+
+func TestRetryRun(t *testing.T) {
+	unavailable := &StatusError{StatusCode: http.StatusServiceUnavailable}
+	tests := []struct {
+		name     string
+		policy   retryPolicy
+		err      error
+		attempts int
+	}{
+		{"retryable", retryPolicy{retries: 3, interval: time.Millisecond, multiplier: 1}, unavailable, 4},
+		{"not retryable", retryPolicy{retries: 3, interval: time.Millisecond, multiplier: 1}, &StatusError{StatusCode: http.StatusNotFound}, 1},
+		{"no retries", retryPolicy{interval: time.Millisecond, multiplier: 1}, unavailable, 1},
+		// waits end at 40ms and 80ms, the third would end past max elapsed
+		{"max elapsed", retryPolicy{retries: 10, interval: 40 * time.Millisecond, multiplier: 1, maxElapsed: 100 * time.Millisecond}, unavailable, 3},
+	}
+
+	for _, test := range tests {
+		attempts := 0
+		err := test.policy.run(NewContext(), "test", func() error {
+			attempts++
+			return test.err
+		}, nil)
+
+		if err != test.err {
+			t.Errorf("%s: got error %v, expect %v", test.name, err, test.err)
+		}
+
+		if attempts != test.attempts {
+			t.Errorf("%s: got %d attempts, expect %d", test.name, attempts, test.attempts)
+		}
+	}
+
+	// succeeds once the failures pass
+	attempts := 0
+	err := retryPolicy{retries: 3, interval: time.Millisecond, multiplier: 1}.run(NewContext(), "test", func() error {
+		attempts++
+		if attempts < 3 {
+			return unavailable
+		}
+		return nil
+	}, nil)
+	if err != nil || attempts != 3 {
+		t.Errorf("recovering: got error %v after %d attempts, expect none after 3", err, attempts)
+	}
+}
+
+func TestRetryRunCanceled(t *testing.T) {
+	done, cancel := context.WithCancel(context.Background())
+	ctx := NewContext()
+	ctx.SetCancel(done)
+
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	attempts := 0
+	start := time.Now()
+	err := retryPolicy{retries: 3, interval: time.Hour, multiplier: 1}.run(ctx, "test", func() error {
+		attempts++
+		return &StatusError{StatusCode: http.StatusServiceUnavailable}
+	}, nil)
+
+	if err != context.Canceled {
+		t.Errorf("got error %v, expect %v", err, context.Canceled)
+	}
+
+	if attempts != 1 || time.Since(start) > 5*time.Second {
+		t.Errorf("got %d attempts in %v, expect the wait to end on cancel", attempts, time.Since(start))
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	exitError := func(code int) error {
+		return exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run()
+	}
+
+	tests := []struct {
+		name   string
+		err    error
+		expect bool
+	}{
+		{"400", &StatusError{StatusCode: http.StatusBadRequest}, false},
+		{"403", &StatusError{StatusCode: http.StatusForbidden}, false},
+		{"404", &StatusError{StatusCode: http.StatusNotFound}, false},
+		{"408", &StatusError{StatusCode: http.StatusRequestTimeout}, true},
+		{"429", &StatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{"500", &StatusError{StatusCode: http.StatusInternalServerError}, true},
+		{"503", &StatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"wrapped 503", fmt.Errorf("fetch: %w", &StatusError{StatusCode: http.StatusServiceUnavailable}), true},
+		{"connection refused", &url.Error{Op: "Get", URL: "http://site.test", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}}, true},
+		{"dns", &net.DNSError{Err: "no such host", Name: "site.test"}, true},
+		{"unexpected eof", &url.Error{Op: "Get", URL: "http://site.test", Err: io.ErrUnexpectedEOF}, true},
+		{"deadline", context.DeadlineExceeded, true},
+		{"unknown authority", &url.Error{Op: "Get", URL: "https://site.test", Err: x509.UnknownAuthorityError{}}, false},
+		{"hostname", &url.Error{Op: "Get", URL: "https://site.test", Err: x509.HostnameError{Host: "site.test", Certificate: &x509.Certificate{}}}, false},
+		{"retry exit code", exitError(75), true},
+		{"other exit code", exitError(1), false},
+		{"other", errors.New("parse failed"), false},
+	}
+
+	policy := retryPolicy{exitCodes: map[int]bool{75: true}}
+	for _, test := range tests {
+		if got := policy.retryable(test.err); got != test.expect {
+			t.Errorf("%s: got retryable %v, expect %v", test.name, got, test.expect)
+		}
+	}
+}
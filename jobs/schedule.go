@@ -0,0 +1,96 @@
+package jobs
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// scheduleKey top-level key of job file schedule used by serve mode
+	scheduleKey = "schedule"
+	// apiVarsKey top-level key listing variables the control api may set on a run
+	apiVarsKey = "api_vars"
+)
+
+// Schedule job file schedule
+type Schedule interface {
+	// Next get next run time after t
+	Next(t time.Time) time.Time
+}
+
+// ReadSchedule read schedule of job file, nil if it has none
+//
+// schedule is a standard 5-field cron expression, a descriptor like @hourly
+// or @every 10m, or an interval like 10m
+func ReadSchedule(filePath string) (Schedule, error) {
+	_, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := loadFile(filePath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	value, found := c[scheduleKey]
+	if !found {
+		return nil, nil
+	}
+
+	expression, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s: expect a string, got %s", scheduleKey, describeType(value))
+	}
+
+	schedule, err := parseSchedule(expression)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", scheduleKey, err)
+	}
+
+	return schedule, nil
+}
+
+// parseSchedule parse cron expression, descriptor or interval
+func parseSchedule(expression string) (Schedule, error) {
+	interval, err := time.ParseDuration(expression)
+	if err == nil {
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval %s must be positive", expression)
+		}
+
+		return cron.Every(interval), nil
+	}
+
+	return cron.ParseStandard(expression)
+}
+
+// ReadAPIVars read variables the control api may set when starting a run of
+// job file, none if it lists none
+//
+//	api_vars = ["date", "bucket"]
+func ReadAPIVars(filePath string) ([]string, error) {
+	_, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := loadFile(filePath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, found := c[apiVarsKey]; !found {
+		return nil, nil
+	}
+
+	names, err := c.Strings(apiVarsKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: expect an array of strings", apiVarsKey)
+	}
+
+	return names, nil
+}
@@ -0,0 +1,183 @@
+package jobs
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/nzai/crawl/constants"
+)
+
+// ErrCanceled branch given up while waiting for a slot
+var ErrCanceled = errors.New("canceled while waiting for a branch slot")
+
+// defaultScheduler used by contexts without a run-wide scheduler
+var defaultScheduler = NewScheduler(constants.DefaultRunParallel, nil)
+
+// Scheduler run-wide branch scheduler
+//
+// every fan-out branch takes a slot before it runs, the total number of running
+// branches is capped by limit and the branches of each depth by levels,
+// when a slot frees up the deepest waiting branch goes first so that started
+// work finishes before new top-level branches start
+type Scheduler struct {
+	limit          int
+	levels         map[int]int
+	mutex          sync.Mutex
+	running        int
+	runningByDepth map[int]int
+	waiters        []*waiter
+	sequence       uint64
+	acquired       uint64
+	maxWaiting     int
+}
+
+// waiter branch waiting for a slot
+type waiter struct {
+	depth    int
+	sequence uint64
+	ready    chan bool
+}
+
+// SchedulerStats scheduler metrics
+type SchedulerStats struct {
+	Limit          int         `json:"limit"`
+	Running        int         `json:"running"`
+	Waiting        int         `json:"waiting"`
+	MaxWaiting     int         `json:"max_waiting"`
+	Acquired       uint64      `json:"acquired"`
+	RunningByDepth map[int]int `json:"running_by_depth"`
+	WaitingByDepth map[int]int `json:"waiting_by_depth"`
+}
+
+// NewScheduler create scheduler, limit <= 0 means no global cap,
+// levels maps job depth (1 for top-level fan-out) to its cap
+func NewScheduler(limit int, levels map[int]int) *Scheduler {
+	return &Scheduler{
+		limit:          limit,
+		levels:         levels,
+		runningByDepth: make(map[int]int),
+	}
+}
+
+// Acquire block until a branch of depth can run or done is closed, a
+// branch given up on leaves the queue and gets the error
+func (s *Scheduler) Acquire(done <-chan struct{}, depth int) error {
+	s.mutex.Lock()
+	s.sequence++
+	w := &waiter{depth: depth, sequence: s.sequence, ready: make(chan bool, 1)}
+	s.waiters = append(s.waiters, w)
+	waitingBranches.WithLabelValues(strconv.Itoa(depth)).Inc()
+	if len(s.waiters) > s.maxWaiting {
+		s.maxWaiting = len(s.waiters)
+	}
+	s.dispatch()
+	s.mutex.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-done:
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for index, queued := range s.waiters {
+		if queued == w {
+			s.waiters = append(s.waiters[:index], s.waiters[index+1:]...)
+			waitingBranches.WithLabelValues(strconv.Itoa(depth)).Dec()
+			return ErrCanceled
+		}
+	}
+
+	// dispatched while giving up, the slot is taken
+	<-w.ready
+	return nil
+}
+
+// reacquire take back the slot of a branch of depth given up while its
+// children ran, once done is closed the slot is taken at once over the
+// limit, since the branch is about to end and release it
+func (s *Scheduler) reacquire(done <-chan struct{}, depth int) {
+	err := s.Acquire(done, depth)
+	if err == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	s.running++
+	s.runningByDepth[depth]++
+	s.mutex.Unlock()
+}
+
+// Release free the slot of a branch of depth
+func (s *Scheduler) Release(depth int) {
+	s.mutex.Lock()
+	s.running--
+	s.runningByDepth[depth]--
+	s.dispatch()
+	s.mutex.Unlock()
+}
+
+// Stats get scheduler metrics
+func (s *Scheduler) Stats() SchedulerStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats := SchedulerStats{
+		Limit:          s.limit,
+		Running:        s.running,
+		Waiting:        len(s.waiters),
+		MaxWaiting:     s.maxWaiting,
+		Acquired:       s.acquired,
+		RunningByDepth: make(map[int]int),
+		WaitingByDepth: make(map[int]int),
+	}
+
+	for depth, running := range s.runningByDepth {
+		if running > 0 {
+			stats.RunningByDepth[depth] = running
+		}
+	}
+
+	for _, w := range s.waiters {
+		stats.WaitingByDepth[w.depth]++
+	}
+
+	return stats
+}
+
+// dispatch hand free slots to waiters, deepest first then first come, must hold mutex
+func (s *Scheduler) dispatch() {
+	for len(s.waiters) > 0 {
+		if s.limit > 0 && s.running >= s.limit {
+			return
+		}
+
+		best := -1
+		for index, w := range s.waiters {
+			limit, found := s.levels[w.depth]
+			if found && limit > 0 && s.runningByDepth[w.depth] >= limit {
+				continue
+			}
+
+			if best < 0 || w.depth > s.waiters[best].depth ||
+				w.depth == s.waiters[best].depth && w.sequence < s.waiters[best].sequence {
+				best = index
+			}
+		}
+
+		if best < 0 {
+			return
+		}
+
+		w := s.waiters[best]
+		s.waiters = append(s.waiters[:best], s.waiters[best+1:]...)
+		waitingBranches.WithLabelValues(strconv.Itoa(w.depth)).Dec()
+		s.running++
+		s.runningByDepth[w.depth]++
+		s.acquired++
+		w.ready <- true
+	}
+}
@@ -0,0 +1,104 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSchedulerAcquireCanceled(t *testing.T) {
+	scheduler := NewScheduler(1, nil)
+	err := scheduler.Acquire(nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waiting := waitingBranches.WithLabelValues("1")
+	before := testutil.ToFloat64(waiting)
+
+	done := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- scheduler.Acquire(done, 1)
+	}()
+
+	for scheduler.Stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if got := testutil.ToFloat64(waiting) - before; got != 1 {
+		t.Errorf("got %v more waiting branches in gauge, expect 1", got)
+	}
+
+	close(done)
+	select {
+	case err = <-result:
+	case <-time.After(time.Second):
+		t.Fatal("acquire still blocked after cancel")
+	}
+
+	if err != ErrCanceled {
+		t.Errorf("got %v, expect %v", err, ErrCanceled)
+	}
+
+	scheduler.Release(1)
+	stats := scheduler.Stats()
+	if stats.Waiting != 0 || stats.Running != 0 {
+		t.Errorf("got %d waiting and %d running, expect none", stats.Waiting, stats.Running)
+	}
+
+	if got := testutil.ToFloat64(waiting); got != before {
+		t.Errorf("got %v waiting branches in gauge after cancel, expect %v", got, before)
+	}
+}
+
+func TestSchedulerDeeperFirst(t *testing.T) {
+	scheduler := NewScheduler(1, nil)
+	err := scheduler.Acquire(nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan int, 2)
+	for _, depth := range []int{1, 2} {
+		go func(depth int) {
+			scheduler.Acquire(nil, depth)
+			order <- depth
+			scheduler.Release(depth)
+		}(depth)
+
+		for scheduler.Stats().WaitingByDepth[depth] == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	scheduler.Release(1)
+	if first := <-order; first != 2 {
+		t.Errorf("depth %d went first, expect 2", first)
+	}
+	<-order
+}
+
+func TestSchedulerReacquireCanceled(t *testing.T) {
+	scheduler := NewScheduler(1, nil)
+	err := scheduler.Acquire(nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	close(done)
+
+	// the slot of a canceled branch is taken back over the limit and released by the branch
+	scheduler.reacquire(done, 2)
+	if running := scheduler.Stats().Running; running != 2 {
+		t.Errorf("got %d running, expect 2", running)
+	}
+
+	scheduler.Release(2)
+	scheduler.Release(1)
+	if running := scheduler.Stats().Running; running != 0 {
+		t.Errorf("got %d running, expect 0", running)
+	}
+}
@@ -0,0 +1,311 @@
+package jobs
+
+import "sort"
+
+// optionType config option value type
+type optionType int
+
+const (
+	optionString optionType = iota
+	optionInt
+	optionBool
+	optionDuration
+	optionStrings
+	optionMap
+	optionRegexp
+	optionFloat
+	optionInts
+)
+
+// String get type name
+func (t optionType) String() string {
+	switch t {
+	case optionString:
+		return "string"
+	case optionInt:
+		return "integer"
+	case optionBool:
+		return "boolean"
+	case optionDuration:
+		return "duration string"
+	case optionStrings:
+		return "array of strings"
+	case optionMap:
+		return "table"
+	case optionRegexp:
+		return "regular expression string"
+	case optionFloat:
+		return "number"
+	case optionInts:
+		return "array of integers"
+	default:
+		return "unknown"
+	}
+}
+
+// option config option schema
+type option struct {
+	Type     optionType
+	Required bool
+}
+
+// actionSchema action config schema
+type actionSchema struct {
+	Options map[string]option
+	// Else key of the sibling table holding else jobs, empty if not supported
+	Else string
+}
+
+// optional options shared by every action
+var commonOptions = map[string]option{
+	"debug": {Type: optionBool},
+}
+
+// fan-out options shared by actions producing multiple contexts
+var fanOutOptions = map[string]option{
+	"parallel": {Type: optionInt},
+	"order":    {Type: optionString},
+}
+
+// retry options shared by actions retrying failed attempts, also the
+// options of the top-level retry policy table
+var retryOptions = map[string]option{
+	"retry":        {Type: optionInt},
+	"interval":     {Type: optionDuration},
+	"max_interval": {Type: optionDuration},
+	"multiplier":   {Type: optionFloat},
+	"jitter":       {Type: optionFloat},
+	"max_elapsed":  {Type: optionDuration},
+}
+
+// storage options shared by aliyun oss actions
+var ossOptions = map[string]option{
+	"endpoint":   {Type: optionString, Required: true},
+	"key_id":     {Type: optionString, Required: true},
+	"key_secret": {Type: optionString, Required: true},
+	"bucket":     {Type: optionString, Required: true},
+	"key":        {Type: optionString, Required: true},
+}
+
+// storage options shared by tencent cloud cos actions
+var cosOptions = map[string]option{
+	"endpoint":   {Type: optionString, Required: true},
+	"key_id":     {Type: optionString, Required: true},
+	"key_secret": {Type: optionString, Required: true},
+	"key":        {Type: optionString, Required: true},
+}
+
+// actionSchemas config schema of every action
+var actionSchemas = map[string]actionSchema{
+	"fetch": {
+		Options: mergeOptions(fanOutOptions, retryOptions, map[string]option{
+			"url":            {Type: optionString, Required: true},
+			"headers":        {Type: optionMap},
+			"status":         {Type: optionStrings},
+			"content_types":  {Type: optionStrings},
+			"max_size":       {Type: optionInt},
+			"must_match":     {Type: optionRegexp},
+			"must_not_match": {Type: optionRegexp},
+			"charset":        {Type: optionString},
+			"proxy":          {Type: optionString},
+			"client":         {Type: optionString},
+			"invalid":        {Type: optionString},
+			"regexp":         {Type: optionRegexp, Required: true},
+			"sets":           {Type: optionStrings, Required: true},
+			"status_set":     {Type: optionString},
+			"url_set":        {Type: optionString},
+		}),
+		Else: "fetch_else",
+	},
+	"render": {
+		Options: mergeOptions(fanOutOptions, retryOptions, map[string]option{
+			"url":     {Type: optionString, Required: true},
+			"browser": {Type: optionString},
+			"headers": {Type: optionMap},
+			"wait":    {Type: optionString},
+			"idle":    {Type: optionDuration},
+			"timeout": {Type: optionDuration},
+			"regexp":  {Type: optionRegexp, Required: true},
+			"sets":    {Type: optionStrings, Required: true},
+		}),
+		Else: "render_else",
+	},
+	"match": {
+		Options: mergeOptions(fanOutOptions, map[string]option{
+			"content": {Type: optionString, Required: true},
+			"regexp":  {Type: optionRegexp, Required: true},
+			"sets":    {Type: optionStrings, Required: true},
+		}),
+		Else: "match_else",
+	},
+	"range": {
+		Options: mergeOptions(fanOutOptions, map[string]option{
+			"expression": {Type: optionString, Required: true},
+			"set":        {Type: optionString, Required: true},
+		}),
+	},
+	"execute": {
+		Options: mergeOptions(retryOptions, map[string]option{
+			"command":          {Type: optionString, Required: true},
+			"args":             {Type: optionStrings, Required: true},
+			"dir":              {Type: optionString},
+			"env":              {Type: optionMap},
+			"stdin":            {Type: optionString},
+			"timeout":          {Type: optionDuration},
+			"stdout_set":       {Type: optionString},
+			"stdout_format":    {Type: optionString},
+			"stderr_set":       {Type: optionString},
+			"regexp":           {Type: optionRegexp},
+			"sets":             {Type: optionStrings},
+			"exit_code_set":    {Type: optionString},
+			"else_exit_codes":  {Type: optionInts},
+			"prefix":           {Type: optionString},
+			"stdout_file":      {Type: optionString},
+			"stderr_file":      {Type: optionString},
+			"output_tail":      {Type: optionInt},
+			"retry_exit_codes": {Type: optionInts},
+		}),
+		Else: "execute_else",
+	},
+	"replace": {
+		Options: mergeOptions(map[string]option{
+			"expression": {Type: optionString, Required: true},
+			"old":        {Type: optionString, Required: true},
+			"new":        {Type: optionString, Required: true},
+			"set":        {Type: optionString, Required: true},
+		}),
+	},
+	"exists": {
+		Options: mergeOptions(map[string]option{
+			"path":     {Type: optionString, Required: true},
+			"continue": {Type: optionBool, Required: true},
+		}),
+		Else: "exists_else",
+	},
+	"list": {
+		Options: mergeOptions(fanOutOptions, map[string]option{
+			"path":      {Type: optionString, Required: true},
+			"pattern":   {Type: optionString, Required: true},
+			"recursive": {Type: optionBool},
+			"path_set":  {Type: optionString, Required: true},
+			"name_set":  {Type: optionString, Required: true},
+		}),
+	},
+	"list_dir": {
+		Options: mergeOptions(fanOutOptions, map[string]option{
+			"path":      {Type: optionString, Required: true},
+			"pattern":   {Type: optionString, Required: true},
+			"recursive": {Type: optionBool},
+			"path_set":  {Type: optionString, Required: true},
+			"name_set":  {Type: optionString, Required: true},
+		}),
+	},
+	"oss_exists": {
+		Options: mergeOptions(ossOptions, retryOptions, map[string]option{
+			"continue": {Type: optionBool, Required: true},
+		}),
+	},
+	"oss_upload": {
+		Options: mergeOptions(ossOptions, retryOptions, map[string]option{
+			"path": {Type: optionString, Required: true},
+		}),
+	},
+	"oss_download": {
+		Options: mergeOptions(ossOptions, retryOptions, map[string]option{
+			"path": {Type: optionString, Required: true},
+		}),
+	},
+	"cos_exists": {
+		Options: mergeOptions(cosOptions, retryOptions, map[string]option{
+			"continue": {Type: optionBool, Required: true},
+		}),
+	},
+	"cos_upload": {
+		Options: mergeOptions(cosOptions, retryOptions, map[string]option{
+			"path": {Type: optionString, Required: true},
+		}),
+	},
+	"cos_download": {
+		Options: mergeOptions(cosOptions, retryOptions, map[string]option{
+			"path": {Type: optionString, Required: true},
+		}),
+	},
+	"use": {
+		Options: mergeOptions(map[string]option{
+			"template": {Type: optionString, Required: true},
+			"params":   {Type: optionMap},
+		}),
+	},
+}
+
+// mergeOptions merge option sets with common options
+func mergeOptions(sets ...map[string]option) map[string]option {
+	options := make(map[string]option)
+	for _, set := range append([]map[string]option{commonOptions}, sets...) {
+		for key, value := range set {
+			options[key] = value
+		}
+	}
+
+	return options
+}
+
+// actionNames get sorted action names
+func actionNames() []string {
+	names := make([]string, 0, len(actionSchemas))
+	for name := range actionSchemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// elseKeys get else table keys
+func elseKeys() map[string]bool {
+	keys := make(map[string]bool)
+	for _, schema := range actionSchemas {
+		if schema.Else != "" {
+			keys[schema.Else] = true
+		}
+	}
+
+	return keys
+}
+
+// OptionInfo option description
+type OptionInfo struct {
+	Name     string
+	Type     string
+	Required bool
+}
+
+// ActionInfo action description
+type ActionInfo struct {
+	Name    string
+	Else    string
+	Options []OptionInfo
+}
+
+// Actions describe every action and its options, sorted by name
+func Actions() []ActionInfo {
+	actions := make([]ActionInfo, 0, len(actionSchemas))
+	for _, name := range actionNames() {
+		schema := actionSchemas[name]
+		names := optionNames(schema)
+		sort.Strings(names)
+
+		options := make([]OptionInfo, len(names))
+		for index, key := range names {
+			options[index] = OptionInfo{
+				Name:     key,
+				Type:     schema.Options[key].Type.String(),
+				Required: schema.Options[key].Required,
+			}
+		}
+
+		actions = append(actions, ActionInfo{Name: name, Else: schema.Else, Options: options})
+	}
+
+	return actions
+}
@@ -0,0 +1,644 @@
+package jobs
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	// ErrInvalidTemplate invalid template expression
+	ErrInvalidTemplate = errors.New("invalid template expression")
+)
+
+// UndefinedError reference undefined variable in strict mode
+type UndefinedError struct {
+	// Job path of the innermost job referencing the variable
+	Job string
+	Key string
+}
+
+// Error name the variable and the path of the job referencing it, e.g. range.fetch.execute
+func (e *UndefinedError) Error() string {
+	if e.Job == "" {
+		return fmt.Sprintf("reference undefined variable [%s]", e.Key)
+	}
+
+	return fmt.Sprintf("job [%s] reference undefined variable [%s]", e.Job, e.Key)
+}
+
+// templateFilter filter value with arguments
+type templateFilter func(value interface{}, args []string) (interface{}, error)
+
+// templateFilters supported template filters
+var templateFilters = map[string]templateFilter{
+	"urlencode":  stringFilter(url.QueryEscape),
+	"pathencode": stringFilter(url.PathEscape),
+	"urldecode":  urlDecodeFilter,
+	"lower":      stringFilter(strings.ToLower),
+	"upper":      stringFilter(strings.ToUpper),
+	"trim":       trimFilter,
+	"pad":        padFilter,
+	"date":       dateFilter,
+	"md5":        hashFilter(func(b []byte) []byte { h := md5.Sum(b); return h[:] }),
+	"sha1":       hashFilter(func(b []byte) []byte { h := sha1.Sum(b); return h[:] }),
+	"sha256":     hashFilter(func(b []byte) []byte { h := sha256.Sum256(b); return h[:] }),
+}
+
+// expandTemplate expand $name and ${expression} placeholders
+//
+// expression syntax:
+//
+//	$$                      literal $
+//	${name}                 variable or dotted path
+//	${name:-default}        default when name is undefined or empty
+//	${page + 1}             arithmetic: + - * / % and parentheses
+//	${name | lower | pad:3} filters, arguments separated by ':'
+func (c *Context) expandTemplate(expression string) (string, error) {
+	if strings.IndexByte(expression, '$') < 0 {
+		return expression, nil
+	}
+
+	var builder strings.Builder
+	for index := 0; index < len(expression); index++ {
+		if expression[index] != '$' || index+1 >= len(expression) {
+			builder.WriteByte(expression[index])
+			continue
+		}
+
+		if expression[index+1] == '$' {
+			builder.WriteByte('$')
+			index++
+			continue
+		}
+
+		if expression[index+1] == '{' {
+			end, err := matchBrace(expression, index+2)
+			if err != nil {
+				return "", err
+			}
+
+			value, err := c.evaluate(expression[index+2 : end])
+			if err != nil {
+				return "", err
+			}
+
+			builder.WriteString(formatValue(value))
+			index = end
+			continue
+		}
+
+		end := index + 1
+		for end < len(expression) && isNameChar(expression[end]) {
+			end++
+		}
+
+		if end == index+1 {
+			builder.WriteByte('$')
+			continue
+		}
+
+		value, err := c.lookup(expression[index+1:end], c.strict)
+		if err != nil {
+			return "", err
+		}
+
+		builder.WriteString(formatValue(value))
+		index = end - 1
+	}
+
+	return builder.String(), nil
+}
+
+// evaluate evaluate expression inside ${}
+func (c *Context) evaluate(expression string) (interface{}, error) {
+	pos := indexTopLevel(expression, ":-")
+	if pos < 0 {
+		return c.evaluatePipeline(expression, c.strict)
+	}
+
+	value, err := c.evaluatePipeline(expression[:pos], true)
+	if err == nil && formatValue(value) != "" {
+		return value, nil
+	}
+
+	if _, ok := err.(*UndefinedError); err != nil && !ok {
+		return nil, err
+	}
+
+	return c.expandTemplate(strings.TrimSpace(expression[pos+2:]))
+}
+
+// evaluatePipeline evaluate arithmetic expression and apply filters
+func (c *Context) evaluatePipeline(expression string, strict bool) (interface{}, error) {
+	segments := splitTopLevel(expression, '|')
+
+	// keys like file-name are looked up as is before parsed as arithmetic
+	key := strings.TrimSpace(segments[0])
+	value, found := c.Get(key)
+	if !found {
+		p := &templateParser{ctx: c, strict: strict, tokens: tokenize(segments[0])}
+
+		var err error
+		value, err = p.parse()
+		if err != nil && !strict && isKey(key) {
+			// undefined keys like file-name expand to their name as before
+			value, err = key, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, segment := range segments[1:] {
+		parts := splitTopLevel(segment, ':')
+		name := strings.TrimSpace(parts[0])
+		filter, found := templateFilters[name]
+		if !found {
+			return nil, fmt.Errorf("%w: unknown filter [%s]", ErrInvalidTemplate, name)
+		}
+
+		args := make([]string, len(parts)-1)
+		for index, part := range parts[1:] {
+			args[index] = unquote(strings.TrimSpace(part))
+		}
+
+		var err error
+		value, err = filter(value, args)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return value, nil
+}
+
+// lookup get variable value, undefined variable expand to its name unless strict
+func (c *Context) lookup(key string, strict bool) (interface{}, error) {
+	value, found := c.Get(key)
+	if found {
+		return value, nil
+	}
+
+	if strict {
+		return nil, &UndefinedError{Key: key}
+	}
+
+	return key, nil
+}
+
+// templateParser recursive descent parser for arithmetic expressions
+type templateParser struct {
+	ctx    *Context
+	strict bool
+	tokens []string
+	pos    int
+}
+
+func (p *templateParser) parse() (interface{}, error) {
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("%w: empty expression", ErrInvalidTemplate)
+	}
+
+	value, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected [%s]", ErrInvalidTemplate, p.tokens[p.pos])
+	}
+
+	return value, nil
+}
+
+func (p *templateParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+
+	return p.tokens[p.pos]
+}
+
+func (p *templateParser) additive() (interface{}, error) {
+	left, err := p.multiplicative()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "+" || p.peek() == "-" {
+		operator := p.tokens[p.pos]
+		p.pos++
+
+		right, err := p.multiplicative()
+		if err != nil {
+			return nil, err
+		}
+
+		left, err = arithmetic(operator, left, right)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return left, nil
+}
+
+func (p *templateParser) multiplicative() (interface{}, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "*" || p.peek() == "/" || p.peek() == "%" {
+		operator := p.tokens[p.pos]
+		p.pos++
+
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		left, err = arithmetic(operator, left, right)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return left, nil
+}
+
+func (p *templateParser) unary() (interface{}, error) {
+	if p.peek() != "-" {
+		return p.primary()
+	}
+
+	p.pos++
+	value, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	return arithmetic("-", 0, value)
+}
+
+func (p *templateParser) primary() (interface{}, error) {
+	token := p.peek()
+	if token == "" {
+		return nil, fmt.Errorf("%w: unexpected end of expression", ErrInvalidTemplate)
+	}
+	p.pos++
+
+	switch {
+	case token == "(":
+		value, err := p.additive()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("%w: missing )", ErrInvalidTemplate)
+		}
+		p.pos++
+
+		return value, nil
+	case token[0] == '"' || token[0] == '\'':
+		return unquote(token), nil
+	case token[0] >= '0' && token[0] <= '9':
+		if intValue, err := strconv.Atoi(token); err == nil {
+			return intValue, nil
+		}
+
+		floatValue, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid number [%s]", ErrInvalidTemplate, token)
+		}
+
+		return floatValue, nil
+	case isNameChar(token[0]):
+		if _, found := p.ctx.Get(token); !found && token == "now" {
+			return time.Now(), nil
+		}
+
+		return p.ctx.lookup(token, p.strict)
+	default:
+		return nil, fmt.Errorf("%w: unexpected [%s]", ErrInvalidTemplate, token)
+	}
+}
+
+// arithmetic apply operator, + concatenates when either side is not a number
+func arithmetic(operator string, left, right interface{}) (interface{}, error) {
+	leftNumber, leftOk := toNumber(left)
+	rightNumber, rightOk := toNumber(right)
+	if !leftOk || !rightOk {
+		if operator == "+" {
+			return formatValue(left) + formatValue(right), nil
+		}
+
+		return nil, fmt.Errorf("%w: operator %s needs numbers, got %s and %s",
+			ErrInvalidTemplate, operator, formatValue(left), formatValue(right))
+	}
+
+	leftInt, leftIsInt := leftNumber.(int)
+	rightInt, rightIsInt := rightNumber.(int)
+	if leftIsInt && rightIsInt {
+		switch operator {
+		case "+":
+			return leftInt + rightInt, nil
+		case "-":
+			return leftInt - rightInt, nil
+		case "*":
+			return leftInt * rightInt, nil
+		case "/", "%":
+			if rightInt == 0 {
+				return nil, fmt.Errorf("%w: division by zero", ErrInvalidTemplate)
+			}
+
+			if operator == "%" {
+				return leftInt % rightInt, nil
+			}
+
+			return leftInt / rightInt, nil
+		}
+	}
+
+	leftFloat, _ := toFloat(leftNumber)
+	rightFloat, _ := toFloat(rightNumber)
+	switch operator {
+	case "+":
+		return leftFloat + rightFloat, nil
+	case "-":
+		return leftFloat - rightFloat, nil
+	case "*":
+		return leftFloat * rightFloat, nil
+	case "/":
+		if rightFloat == 0 {
+			return nil, fmt.Errorf("%w: division by zero", ErrInvalidTemplate)
+		}
+
+		return leftFloat / rightFloat, nil
+	default:
+		return nil, fmt.Errorf("%w: operator %s needs integers", ErrInvalidTemplate, operator)
+	}
+}
+
+// toNumber convert value to int or float64
+func toNumber(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case int, float64:
+		return v, true
+	case string:
+		if intValue, err := strconv.Atoi(v); err == nil {
+			return intValue, true
+		}
+
+		if floatValue, err := strconv.ParseFloat(v, 64); err == nil {
+			return floatValue, true
+		}
+	}
+
+	return nil, false
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	number, ok := toNumber(value)
+	if !ok {
+		return 0, false
+	}
+
+	if intValue, ok := number.(int); ok {
+		return float64(intValue), true
+	}
+
+	return number.(float64), true
+}
+
+func stringFilter(fun func(string) string) templateFilter {
+	return func(value interface{}, args []string) (interface{}, error) {
+		return fun(formatValue(value)), nil
+	}
+}
+
+func urlDecodeFilter(value interface{}, args []string) (interface{}, error) {
+	decoded, err := url.QueryUnescape(formatValue(value))
+	if err != nil {
+		return nil, fmt.Errorf("%w: urldecode %v", ErrInvalidTemplate, err)
+	}
+
+	return decoded, nil
+}
+
+func trimFilter(value interface{}, args []string) (interface{}, error) {
+	if len(args) == 0 {
+		return strings.TrimSpace(formatValue(value)), nil
+	}
+
+	return strings.Trim(formatValue(value), args[0]), nil
+}
+
+// padFilter left pad value to width, with zero by default
+func padFilter(value interface{}, args []string) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%w: pad needs width", ErrInvalidTemplate)
+	}
+
+	width, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid pad width [%s]", ErrInvalidTemplate, args[0])
+	}
+
+	padding := "0"
+	if len(args) > 1 && args[1] != "" {
+		padding = args[1]
+	}
+
+	text := formatValue(value)
+	for len([]rune(text)) < width {
+		text = padding + text
+	}
+
+	return text, nil
+}
+
+// dateFilter format time value, args: output layout and optional input layout
+//
+// value can be the built-in now, unix seconds or a RFC3339 string
+func dateFilter(value interface{}, args []string) (interface{}, error) {
+	layout := time.RFC3339
+	if len(args) > 0 && args[0] != "" {
+		layout = args[0]
+	}
+
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case int:
+		t = time.Unix(int64(v), 0)
+	case float64:
+		t = time.Unix(int64(v), 0)
+	default:
+		text := formatValue(v)
+		inputLayout := time.RFC3339
+		if len(args) > 1 && args[1] != "" {
+			inputLayout = args[1]
+		}
+
+		var err error
+		t, err = time.Parse(inputLayout, text)
+		if err != nil {
+			seconds, err1 := strconv.ParseInt(text, 10, 64)
+			if err1 != nil {
+				return nil, fmt.Errorf("%w: date %v", ErrInvalidTemplate, err)
+			}
+
+			t = time.Unix(seconds, 0)
+		}
+	}
+
+	return t.Format(layout), nil
+}
+
+func hashFilter(sum func([]byte) []byte) templateFilter {
+	return func(value interface{}, args []string) (interface{}, error) {
+		return hex.EncodeToString(sum([]byte(formatValue(value)))), nil
+	}
+}
+
+// tokenize split arithmetic expression into tokens
+func tokenize(expression string) []string {
+	var tokens []string
+	for index := 0; index < len(expression); {
+		ch := expression[index]
+		switch {
+		case unicode.IsSpace(rune(ch)):
+			index++
+		case ch == '"' || ch == '\'':
+			end := index + 1
+			for end < len(expression) && expression[end] != ch {
+				end++
+			}
+
+			if end < len(expression) {
+				end++
+			}
+
+			tokens = append(tokens, expression[index:end])
+			index = end
+		case isNameChar(ch) || ch == '.':
+			end := index + 1
+			for end < len(expression) && (isNameChar(expression[end]) || expression[end] == '.') {
+				end++
+			}
+
+			tokens = append(tokens, expression[index:end])
+			index = end
+		default:
+			tokens = append(tokens, string(ch))
+			index++
+		}
+	}
+
+	return tokens
+}
+
+// matchBrace find the } closing a ${ which content starts at start
+func matchBrace(expression string, start int) (int, error) {
+	depth := 0
+	var quote byte
+	for index := start; index < len(expression); index++ {
+		ch := expression[index]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '{':
+			depth++
+		case ch == '}':
+			if depth == 0 {
+				return index, nil
+			}
+			depth--
+		}
+	}
+
+	return 0, fmt.Errorf("%w: missing } in %s", ErrInvalidTemplate, expression)
+}
+
+// indexTopLevel find separator outside quotes and nested braces
+func indexTopLevel(expression, separator string) int {
+	depth := 0
+	var quote byte
+	for index := 0; index < len(expression); index++ {
+		ch := expression[index]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '{' || ch == '(':
+			depth++
+		case ch == '}' || ch == ')':
+			depth--
+		case depth == 0 && strings.HasPrefix(expression[index:], separator):
+			return index
+		}
+	}
+
+	return -1
+}
+
+// splitTopLevel split expression by separator outside quotes and nested braces
+func splitTopLevel(expression string, separator byte) []string {
+	var parts []string
+	for {
+		pos := indexTopLevel(expression, string(separator))
+		if pos < 0 {
+			return append(parts, expression)
+		}
+
+		parts = append(parts, expression[:pos])
+		expression = expression[pos+1:]
+	}
+}
+
+func unquote(text string) string {
+	if len(text) >= 2 && (text[0] == '"' || text[0] == '\'') && text[len(text)-1] == text[0] {
+		return text[1 : len(text)-1]
+	}
+
+	return text
+}
+
+// isKey check whether text is a bare key, names joined by - or . without spaces
+func isKey(text string) bool {
+	if text == "" {
+		return false
+	}
+
+	for index := 0; index < len(text); index++ {
+		if !isNameChar(text[index]) && text[index] != '-' && text[index] != '.' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isNameChar(ch byte) bool {
+	return ch == '_' || ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z'
+}
@@ -0,0 +1,98 @@
+package jobs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetValue("page", 3)
+	ctx.Set("file-name", "index.html")
+	ctx.Set("name", "Go Crawl")
+
+	tests := []struct {
+		expression string
+		expect     string
+	}{
+		{"${page + 1}", "4"},
+		{"${page-1}", "2"},
+		{"${file-name}", "index.html"},
+		{"${name | lower | urlencode}", "go+crawl"},
+		{"${missing:-x}", "x"},
+		{"${page | pad:3}", "003"},
+		{"$$page", "$page"},
+		// undefined keys expand to their name outside strict mode
+		{"$missing", "missing"},
+		{"${missing}", "missing"},
+		{"${other-name}", "other-name"},
+		{"${other-1}", "other-1"},
+		{"${item.author.name}", "item.author.name"},
+	}
+
+	for _, test := range tests {
+		value, err := ctx.Expand(test.expression)
+		if err != nil {
+			t.Errorf("expand %s failed: %v", test.expression, err)
+			continue
+		}
+
+		if value != test.expect {
+			t.Errorf("expand %s = %s, expect %s", test.expression, value, test.expect)
+		}
+	}
+}
+
+func TestExpandTemplateErrors(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetValue("page", 3)
+
+	tests := []string{
+		"${page + }",
+		"${page / 0}",
+		"${page | unknown}",
+		"${page",
+	}
+
+	for _, expression := range tests {
+		_, err := ctx.Expand(expression)
+		if !errors.Is(err, ErrInvalidTemplate) {
+			t.Errorf("expand %s: got %v, expect %v", expression, err, ErrInvalidTemplate)
+		}
+	}
+}
+
+func TestExpandTemplateStrict(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetStrict(true)
+
+	for _, expression := range []string{"$missing", "${missing}", "${other-name}"} {
+		_, err := ctx.Expand(expression)
+
+		var undefined *UndefinedError
+		if !errors.As(err, &undefined) {
+			t.Errorf("expand %s: got %v, expect undefined variable error", expression, err)
+		}
+	}
+
+	// nested jobs sharing a name are told apart by their path
+	job := &Job{Name: "range", Path: "range", Action: &Range{start: "1", end: "1", set: "page"}, Jobs: []*Job{
+		{Name: "execute", Path: "range.execute", Action: doFunc(func(*Context) error { return nil })},
+		{Name: "execute", Path: "range.execute[1]", Action: doFunc(func(ctx *Context) error {
+			_, err := ctx.Expand("${missing}")
+			return err
+		})},
+	}}
+
+	err := job.Execute(ctx)
+
+	var undefined *UndefinedError
+	if !errors.As(err, &undefined) || undefined.Job != "range.execute[1]" {
+		t.Errorf("execute job tree: got %v, expect undefined variable error of job range.execute[1]", err)
+	}
+
+	value, err := ctx.Expand("${missing:-x}")
+	if err != nil || value != "x" {
+		t.Errorf("expand default in strict mode = %s, %v, expect x", value, err)
+	}
+}
@@ -0,0 +1,71 @@
+package jobs
+
+import (
+	"github.com/nzai/crawl/tracing"
+)
+
+// attributedAction action which describes its expanded options as span attributes
+type attributedAction interface {
+	Attributes(*Context) map[string]string
+}
+
+// StartTrace trace jobs run with context as spans of a new trace, returns function ending the root span
+func (c *Context) StartTrace(tracer *tracing.Tracer, name string) func(error) {
+	c.span = tracer.Start(nil, name)
+	return c.span.End
+}
+
+// startSpan start child span of the current job, attributes are key value pairs
+func (c *Context) startSpan(name string, attributes ...string) *tracing.Span {
+	span := c.span.Start(name)
+	for index := 0; index+1 < len(attributes); index += 2 {
+		span.SetAttribute(attributes[index], attributes[index+1])
+	}
+
+	return span
+}
+
+// withSpan get view of context with span current, values are still read from
+// and written to context while its own span stays unchanged
+func (c *Context) withSpan(span *tracing.Span) *Context {
+	return &Context{
+		parent:    c,
+		strict:    c.strict,
+		depth:     c.depth,
+		scheduler: c.scheduler,
+		slot:      c.slot,
+		plan:      c.plan,
+		cancel:    c.cancel,
+		logger:    c.logger,
+		progress:  c.progress,
+		span:      span,
+		scope:     c,
+	}
+}
+
+// traceJob start span of job, returns the context the job and its child jobs run with
+// while the span is current and the function ending the span
+func (s Job) traceJob(ctx *Context) (*Context, func(error)) {
+	if ctx.span == nil {
+		return ctx, func(error) {}
+	}
+
+	span := ctx.startSpan(s.Name, "job.path", s.Path)
+	if action, ok := s.Action.(attributedAction); ok {
+		for key, value := range action.Attributes(ctx) {
+			span.SetAttribute(key, value)
+		}
+	}
+
+	return ctx.withSpan(span), span.End
+}
+
+// expandAttribute expand option for span attribute, the raw option on error
+func expandAttribute(ctx *Context, expression string) string {
+	value, err := ctx.Expand(expression)
+	if err != nil {
+		return expression
+	}
+
+	return value
+}
@@ -0,0 +1,144 @@
+package jobs
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/nzai/crawl/tracing"
+)
+
+// spanRecorder exporter keeping finished spans
+type spanRecorder struct {
+	spans []tracing.SpanData
+	mutex sync.Mutex
+}
+
+// Export export spans
+func (r *spanRecorder) Export(spans []tracing.SpanData) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+// Close close exporter
+func (r *spanRecorder) Close() error {
+	return nil
+}
+
+func TestTraceJob(t *testing.T) {
+	recorder := new(spanRecorder)
+	tracer := tracing.NewTracer(recorder)
+
+	ctx := NewContext()
+	endTrace := ctx.StartTrace(tracer, "run")
+	root := ctx.span
+
+	var mutex sync.Mutex
+	pages := make(map[string]bool)
+	job := &Job{Name: "range", Action: &Range{start: "1", end: "4", set: "page", parallel: 4}, Jobs: []*Job{
+		{Name: "set", Action: doFunc(func(ctx *Context) error {
+			page, err := ctx.String("page")
+			if err != nil {
+				return err
+			}
+
+			ctx.Set("seen", page)
+			return nil
+		})},
+		{Name: "check", Action: doFunc(func(ctx *Context) error {
+			// values set by a traced sibling reach later siblings
+			seen, err := ctx.String("seen")
+			if err != nil {
+				return err
+			}
+
+			mutex.Lock()
+			pages[seen] = true
+			mutex.Unlock()
+			return nil
+		})},
+	}}
+
+	err := job.Execute(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ctx.span != root {
+		t.Error("span of caller context changed")
+	}
+
+	endTrace(nil)
+	err = tracer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pages) != 4 {
+		t.Errorf("got pages %v seen by check, expect 4", pages)
+	}
+
+	ids := make(map[string]string)
+	for _, span := range recorder.spans {
+		ids[span.Name] = span.SpanID
+	}
+
+	parents := map[string]string{"range": "run", "set": "range", "check": "range"}
+	counts := make(map[string]int)
+	for _, span := range recorder.spans {
+		counts[span.Name]++
+		parent, found := parents[span.Name]
+		if found && span.ParentID != ids[parent] {
+			t.Errorf("span %s: got parent %s, expect %s", span.Name, span.ParentID, parent)
+		}
+	}
+
+	if counts["set"] != 4 || counts["check"] != 4 {
+		t.Errorf("got %d set and %d check spans, expect 4 each", counts["set"], counts["check"])
+	}
+}
+
+func TestTraceJobSharedContext(t *testing.T) {
+	recorder := new(spanRecorder)
+	tracer := tracing.NewTracer(recorder)
+
+	ctx := NewContext()
+	endTrace := ctx.StartTrace(tracer, "run")
+
+	// jobs running at once on one context each get their own span
+	wg := new(sync.WaitGroup)
+	for index := 0; index < 4; index++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			job := &Job{Name: "parent", Action: doFunc(func(*Context) error { return nil }), Jobs: []*Job{
+				{Name: "child", Action: doFunc(func(*Context) error { return nil })},
+			}}
+			err := job.Execute(ctx)
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	endTrace(nil)
+	err := tracer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make(map[string]string)
+	for _, span := range recorder.spans {
+		names[span.SpanID] = span.Name
+	}
+
+	for _, span := range recorder.spans {
+		if span.Name == "child" && names[span.ParentID] != "parent" {
+			t.Errorf("child span under %s, expect parent", names[span.ParentID])
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package jobs
+
+import (
+	"fmt"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// Use run jobs of a template with parameters
+//
+// the config loader copies the template jobs into the use table,
+// parameters are set on a child context so they only reach the template jobs,
+// which run inline in the branch of the use job
+type Use struct {
+	template string
+	params   map[string]interface{}
+	debug    bool
+}
+
+// newUse create use action
+func newUse(c *Config) (interface{}, error) {
+	template, err := c.String("template")
+	if err != nil {
+		return nil, err
+	}
+
+	params := make(map[string]interface{})
+	if value, found := (*c)["params"]; found {
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("key [params] value %+v is not a map", value)
+		}
+
+		params = table
+	}
+
+	debug := c.BoolDefault("debug", false)
+
+	return &Use{
+		template: template,
+		params:   params,
+		debug:    debug,
+	}, nil
+}
+
+// Attributes describe expanded options for tracing
+func (s Use) Attributes(ctx *Context) map[string]string {
+	return map[string]string{"template": s.template}
+}
+
+// Scope get context of the template jobs with parameters, string parameters are expanded
+func (s Use) Scope(ctx *Context) (*Context, error) {
+	keys := make([]string, 0, len(s.params))
+	for key := range s.params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	scoped := ctx.inline()
+	for _, key := range keys {
+		value := s.params[key]
+		if text, ok := value.(string); ok {
+			expanded, err := ctx.Expand(text)
+			if err != nil {
+				return nil, err
+			}
+
+			value = expanded
+		}
+
+		scoped.SetValue(key, value)
+	}
+
+	if s.debug {
+		ctx.L().Debug("use template", zap.String("template", s.template), zap.Any("params", s.params))
+	}
+
+	return scoped, nil
+}
@@ -0,0 +1,69 @@
+package jobs
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestUseInline(t *testing.T) {
+	var mutex sync.Mutex
+	var seen, leaked []string
+	depths := make(map[int]bool)
+
+	use, err := newUse(&Config{"template": "upload", "params": map[string]interface{}{"bucket": "b-${page}"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := []*Job{{Name: "range", Action: &Range{start: "1", end: "3", set: "page", parallel: 3, ordering: ordering{order: orderBranch}}, Jobs: []*Job{
+		{Name: "use", Action: use, Jobs: []*Job{
+			{Name: "upload", Action: doFunc(func(ctx *Context) error {
+				page, _ := ctx.String("page")
+				bucket, _ := ctx.String("bucket")
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				seen = append(seen, page+":"+bucket)
+				depths[ctx.depth] = ctx.slot
+				return nil
+			})},
+		}},
+		{Name: "after", Action: doFunc(func(ctx *Context) error {
+			if _, found := ctx.Get("bucket"); found {
+				mutex.Lock()
+				defer mutex.Unlock()
+				leaked = append(leaked, fmt.Sprint(ctx.Get("bucket")))
+			}
+			return nil
+		})},
+	}}}
+
+	scheduler := NewScheduler(0, nil)
+	ctx := NewContext()
+	ctx.SetScheduler(scheduler)
+
+	err = jobs[0].Execute(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(seen)
+	if fmt.Sprint(seen) != "[1:b-1 2:b-2 3:b-3]" {
+		t.Errorf("got template runs %v, expect every page with its bucket", seen)
+	}
+
+	if len(leaked) > 0 {
+		t.Errorf("got params %v after use, expect them only in the template jobs", leaked)
+	}
+
+	// the template jobs run in the branch of the range, without a slot of their own
+	if slot, found := depths[1]; len(depths) != 1 || !found || !slot {
+		t.Errorf("got template jobs at depths %v, expect depth 1 in the slot of the range branch", depths)
+	}
+
+	if acquired := scheduler.Stats().Acquired; acquired != 3 {
+		t.Errorf("got %d slots acquired, expect 3 for the range branches only", acquired)
+	}
+}
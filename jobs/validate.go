@@ -0,0 +1,710 @@
+package jobs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseErrorLine line number in toml parse error
+var parseErrorLine = regexp.MustCompile(`line (\d+)`)
+
+// Problem config problem found by validation
+type Problem struct {
+	File    string
+	Line    int
+	Path    string
+	Message string
+}
+
+// String format problem as file:line: path: message
+func (p Problem) String() string {
+	location := p.File
+	if p.Line > 0 {
+		location = fmt.Sprintf("%s:%d", p.File, p.Line)
+	}
+
+	if p.Path == "" {
+		return fmt.Sprintf("%s: %s", location, p.Message)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", location, p.Path, p.Message)
+}
+
+// validator check config against action schemas
+type validator struct {
+	file      string
+	lines     map[string]int
+	templates map[string]bool
+	proxies   map[string]bool
+	clients   map[string]bool
+	problems  []Problem
+}
+
+// ValidateFile validate job file, problems are sorted by line
+func ValidateFile(filePath string) ([]Problem, error) {
+	_, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := decodeFile(filePath)
+	if err != nil {
+		problem := Problem{File: filePath, Message: err.Error()}
+		groups := parseErrorLine.FindStringSubmatch(err.Error())
+		if len(groups) == 2 {
+			problem.Line, _ = strconv.Atoi(groups[1])
+		}
+
+		return []Problem{problem}, nil
+	}
+
+	lines, err := locateLines(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &validator{file: filePath, lines: lines, templates: make(map[string]bool), proxies: make(map[string]bool), clients: make(map[string]bool)}
+
+	// templates may come from included files
+	merged, err := loadFile(filePath, nil)
+	if err != nil {
+		v.report(includeKey, "%v", err)
+		merged = c
+	}
+
+	if templates, ok := merged[templatesKey].(map[string]interface{}); ok {
+		for name := range templates {
+			v.templates[name] = true
+		}
+	}
+
+	// so are proxy pools and http clients
+	if pools, ok := merged[proxiesKey].(map[string]interface{}); ok {
+		for name := range pools {
+			v.proxies[name] = true
+		}
+	}
+
+	if clients, ok := merged[httpClientsKey].(map[string]interface{}); ok {
+		for name := range clients {
+			v.clients[name] = true
+		}
+	}
+
+	v.validateJobs("", c)
+
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		return v.problems[i].Line < v.problems[j].Line
+	})
+
+	return v.problems, nil
+}
+
+// report add problem at path
+func (v *validator) report(path, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{
+		File:    v.file,
+		Line:    v.line(path),
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// line find line of path or its nearest parent
+func (v *validator) line(path string) int {
+	for path != "" {
+		line, found := v.lines[path]
+		if found {
+			return line
+		}
+
+		pos := strings.LastIndexAny(path, ".[")
+		if pos < 0 {
+			return 0
+		}
+		path = path[:pos]
+	}
+
+	return 0
+}
+
+// validateJobs validate table which keys are actions
+func (v *validator) validateJobs(path string, c Config) {
+	elses := elseKeys()
+	for _, key := range sortedKeys(c) {
+		value := c[key]
+		keyPath := joinPath(path, key)
+
+		if key == "headers" {
+			continue
+		}
+
+		if path == "" && key == includeKey {
+			if _, err := c.includes(); err != nil {
+				v.report(keyPath, "expect a string or an array of strings, got %s", describeType(value))
+			}
+			continue
+		}
+
+		if path == "" && key == scheduleKey {
+			expression, ok := value.(string)
+			if !ok {
+				v.report(keyPath, "expect a string, got %s", describeType(value))
+			} else if _, err := parseSchedule(expression); err != nil {
+				v.report(keyPath, "invalid schedule: %v", err)
+			}
+			continue
+		}
+
+		if path == "" && key == apiVarsKey {
+			v.validateOption(keyPath, option{Type: optionStrings}, value)
+			continue
+		}
+
+		if path == "" && key == templatesKey {
+			v.validateTemplates(keyPath, value)
+			continue
+		}
+
+		if path == "" && key == proxiesKey {
+			v.validateProxies(keyPath, value)
+			continue
+		}
+
+		if path == "" && key == httpClientsKey {
+			v.validateHTTPClients(keyPath, value)
+			continue
+		}
+
+		if path == "" && key == retryPolicyKey {
+			v.validateRetryPolicy(keyPath, value)
+			continue
+		}
+
+		if key == stepsKey {
+			v.validateSteps(keyPath, value)
+			continue
+		}
+
+		if elses[key] {
+			v.validateElse(keyPath, key, value, c)
+			continue
+		}
+
+		tables, ok := toTables(value)
+		if !ok {
+			v.report(keyPath, "unknown key, jobs table only contains actions%s",
+				suggest(key, actionNames()))
+			continue
+		}
+
+		schema, found := actionSchemas[key]
+		if !found {
+			v.report(keyPath, "unknown action%s", suggest(key, actionNames()))
+			continue
+		}
+
+		for index, table := range tables {
+			tablePath := keyPath
+			if _, isArray := value.([]map[string]interface{}); isArray {
+				tablePath = fmt.Sprintf("%s[%d]", keyPath, index)
+			}
+
+			v.validateAction(tablePath, key, schema, table)
+		}
+	}
+}
+
+// validateSteps validate ordered job tables
+func (v *validator) validateSteps(path string, value interface{}) {
+	steps, ok := value.([]map[string]interface{})
+	if !ok {
+		v.report(path, "expect an array of job tables")
+		return
+	}
+
+	for index, step := range steps {
+		v.validateJobs(fmt.Sprintf("%s[%d]", path, index), step)
+	}
+}
+
+// validateTemplates validate tables of template jobs
+func (v *validator) validateTemplates(path string, value interface{}) {
+	templates, ok := value.(map[string]interface{})
+	if !ok {
+		v.report(path, "expect a table of templates")
+		return
+	}
+
+	for _, name := range sortedKeys(templates) {
+		template, ok := templates[name].(map[string]interface{})
+		if !ok {
+			v.report(joinPath(path, name), "expect a table of jobs")
+			continue
+		}
+
+		v.validateJobs(joinPath(path, name), template)
+	}
+}
+
+// proxyPoolOptions options of a proxy pool table
+var proxyPoolOptions = map[string]option{
+	"urls":         {Type: optionStrings, Required: true},
+	"rotation":     {Type: optionString},
+	"max_failures": {Type: optionInt},
+	"eject":        {Type: optionDuration},
+}
+
+// httpClientOptions options of an http client table
+var httpClientOptions = map[string]option{
+	"timeout":                 {Type: optionDuration},
+	"connect_timeout":         {Type: optionDuration},
+	"tls_handshake_timeout":   {Type: optionDuration},
+	"response_header_timeout": {Type: optionDuration},
+	"idle_conn_timeout":       {Type: optionDuration},
+	"keep_alive":              {Type: optionDuration},
+	"disable_keep_alives":     {Type: optionBool},
+	"max_idle_conns":          {Type: optionInt},
+	"max_idle_conns_per_host": {Type: optionInt},
+	"max_conns_per_host":      {Type: optionInt},
+	"http2":                   {Type: optionBool},
+	"max_redirects":           {Type: optionInt},
+	"insecure_skip_verify":    {Type: optionBool},
+	"ca_file":                 {Type: optionString},
+	"cert_file":               {Type: optionString},
+	"key_file":                {Type: optionString},
+	"server_name":             {Type: optionString},
+	"min_tls_version":         {Type: optionString},
+	"user_agents":             {Type: optionStrings},
+	"user_agent_rotation":     {Type: optionString},
+}
+
+// validateProxies validate table of proxy pools
+func (v *validator) validateProxies(path string, value interface{}) {
+	v.validateResources(path, value, "proxy pool", proxyPoolOptions, func(name string, c Config) error {
+		_, err := newProxyPool(name, c)
+		return err
+	})
+}
+
+// validateHTTPClients validate table of http clients
+func (v *validator) validateHTTPClients(path string, value interface{}) {
+	v.validateResources(path, value, "http client", httpClientOptions, func(name string, c Config) error {
+		_, err := newHTTPClient(name, c)
+		return err
+	})
+}
+
+// validateRetryPolicy validate retry policy table
+func (v *validator) validateRetryPolicy(path string, value interface{}) {
+	table, ok := value.(map[string]interface{})
+	if !ok {
+		v.report(path, "expect a table")
+		return
+	}
+
+	options := map[string]option{"retry_exit_codes": {Type: optionInts}}
+	for key, opt := range retryOptions {
+		options[key] = opt
+	}
+
+	valid := true
+	for _, key := range sortedKeys(table) {
+		opt, found := options[key]
+		if !found {
+			v.report(joinPath(path, key), "unknown option of retry policy%s", suggest(key, optionNames(actionSchema{Options: options})))
+			valid = false
+			continue
+		}
+
+		before := len(v.problems)
+		v.validateOption(joinPath(path, key), opt, table[key])
+		valid = valid && len(v.problems) == before
+	}
+
+	if valid {
+		if _, err := newRetryPolicy(Config(table), defaultRetryPolicy); err != nil {
+			v.report(path, "%v", err)
+		}
+	}
+}
+
+// validateResources validate table of named resources, create checks the
+// values of resources which options are well typed
+func (v *validator) validateResources(path string, value interface{}, kind string, options map[string]option, create func(name string, c Config) error) {
+	resources, ok := value.(map[string]interface{})
+	if !ok {
+		v.report(path, "expect a table of %ss", kind)
+		return
+	}
+
+	for _, name := range sortedKeys(resources) {
+		resourcePath := joinPath(path, name)
+		table, ok := resources[name].(map[string]interface{})
+		if !ok {
+			v.report(resourcePath, "expect a table")
+			continue
+		}
+
+		valid := true
+		for key, opt := range options {
+			if _, found := table[key]; !found && opt.Required {
+				v.report(resourcePath, "missing required option [%s] (%s)", key, opt.Type)
+				valid = false
+			}
+		}
+
+		for _, key := range sortedKeys(table) {
+			opt, found := options[key]
+			if !found {
+				v.report(joinPath(resourcePath, key), "unknown option of %s%s", kind, suggest(key, optionNames(actionSchema{Options: options})))
+				valid = false
+				continue
+			}
+
+			before := len(v.problems)
+			v.validateOption(joinPath(resourcePath, key), opt, table[key])
+			valid = valid && len(v.problems) == before
+		}
+
+		if valid {
+			if err := create(name, Config(table)); err != nil {
+				v.report(resourcePath, "%v", err)
+			}
+		}
+	}
+}
+
+// validateElse validate else jobs table of sibling action
+func (v *validator) validateElse(path, key string, value interface{}, parent Config) {
+	action := strings.TrimSuffix(key, "_else")
+	if _, found := parent[action]; !found {
+		v.report(path, "else jobs without [%s] action beside it", action)
+	}
+
+	table, ok := value.(map[string]interface{})
+	if !ok {
+		v.report(path, "expect a table of jobs")
+		return
+	}
+
+	v.validateJobs(path, table)
+}
+
+// validateAction validate options and sub jobs of action table
+func (v *validator) validateAction(path, name string, schema actionSchema, c Config) {
+	before := len(v.problems)
+	for key, opt := range schema.Options {
+		if _, found := c[key]; !found && opt.Required {
+			v.report(path, "missing required option [%s] (%s)", key, opt.Type)
+		}
+	}
+
+	jobs := make(Config)
+	for _, key := range sortedKeys(c) {
+		value := c[key]
+		keyPath := joinPath(path, key)
+
+		opt, found := schema.Options[key]
+		if found {
+			v.validateOption(keyPath, opt, value)
+			continue
+		}
+
+		if _, ok := toTables(value); ok || elseKeys()[key] {
+			jobs[key] = value
+			continue
+		}
+
+		candidates := append(optionNames(schema), actionNames()...)
+		v.report(keyPath, "unknown option of [%s]%s", name, suggest(key, candidates))
+	}
+
+	// retry values, once their types are right
+	if _, retries := schema.Options["max_elapsed"]; retries && len(v.problems) == before {
+		if _, err := newRetrying(&c); err != nil {
+			v.report(path, "%v", err)
+		}
+	}
+
+	v.validateJobs(path, jobs)
+
+	if name == useKey {
+		template, ok := c["template"].(string)
+		if ok && !v.templates[template] {
+			names := make([]string, 0, len(v.templates))
+			for name := range v.templates {
+				names = append(names, name)
+			}
+
+			v.report(joinPath(path, "template"), "unknown template [%s]%s", template, suggest(template, names))
+		}
+	}
+
+	if proxy, ok := c["proxy"].(string); ok && proxy != "" && proxy != proxyDirect && !v.proxies[proxy] {
+		if _, err := proxyPoolOf(proxy, nil); err != nil {
+			names := make([]string, 0, len(v.proxies))
+			for name := range v.proxies {
+				names = append(names, name)
+			}
+
+			v.report(joinPath(path, "proxy"), "%v%s", err, suggest(proxy, names))
+		}
+	}
+
+	if client, ok := c["client"].(string); ok && client != "" && !v.clients[client] {
+		names := make([]string, 0, len(v.clients))
+		for name := range v.clients {
+			names = append(names, name)
+		}
+
+		v.report(joinPath(path, "client"), "unknown http client [%s]%s", client, suggest(client, names))
+	}
+
+	// regexp groups must match the number of keys to set
+	expression, ok := c["regexp"].(string)
+	if !ok {
+		return
+	}
+
+	sets, ok := c["sets"].([]interface{})
+	if !ok {
+		return
+	}
+
+	regex, err := regexp.Compile(expression)
+	if err == nil && regex.NumSubexp() != len(sets) {
+		v.report(joinPath(path, "sets"), "regexp has %d groups but sets has %d keys",
+			regex.NumSubexp(), len(sets))
+	}
+}
+
+// validateOption check option value type
+func (v *validator) validateOption(path string, opt option, value interface{}) {
+	valid := false
+	switch opt.Type {
+	case optionString:
+		_, valid = value.(string)
+	case optionInt:
+		_, valid = value.(int64)
+	case optionBool:
+		_, valid = value.(bool)
+	case optionDuration:
+		var text string
+		text, valid = value.(string)
+		if valid {
+			if _, err := time.ParseDuration(text); err != nil {
+				v.report(path, "invalid duration: %v", err)
+				return
+			}
+		}
+	case optionStrings:
+		var array []interface{}
+		array, valid = value.([]interface{})
+		for _, item := range array {
+			if _, ok := item.(string); !ok {
+				valid = false
+			}
+		}
+	case optionMap:
+		_, valid = value.(map[string]interface{})
+	case optionFloat:
+		switch value.(type) {
+		case float64, int64:
+			valid = true
+		}
+	case optionInts:
+		var array []interface{}
+		array, valid = value.([]interface{})
+		for _, item := range array {
+			if _, ok := item.(int64); !ok {
+				valid = false
+			}
+		}
+	case optionRegexp:
+		var text string
+		text, valid = value.(string)
+		if valid {
+			if _, err := regexp.Compile(text); err != nil {
+				v.report(path, "invalid regexp: %v", err)
+				return
+			}
+		}
+	}
+
+	if !valid {
+		v.report(path, "expect %s, got %s", opt.Type, describeType(value))
+	}
+}
+
+// locateTomlLines map table and key paths to their line numbers
+//
+// paths of array tables carry their index, e.g. range.execute[1].command
+func locateTomlLines(filePath string) (map[string]int, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := make(map[string]int)
+	arrays := make(map[string]int)
+	current := ""
+
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			isArray := strings.HasPrefix(line, "[[")
+			header := strings.Trim(strings.SplitN(line, "]", 2)[0], "[ ")
+			if isArray {
+				header = strings.Trim(strings.SplitN(line, "]]", 2)[0], "[ ")
+			}
+
+			parts := strings.Split(header, ".")
+			current = ""
+			for index, part := range parts {
+				current = joinPath(current, strings.Trim(strings.TrimSpace(part), `"'`))
+				last := index == len(parts)-1
+				if last && isArray {
+					arrays[current]++
+				}
+
+				if count, found := arrays[current]; found {
+					current = fmt.Sprintf("%s[%d]", current, count-1)
+				}
+			}
+
+			lines[current] = number
+			continue
+		}
+
+		pos := strings.Index(line, "=")
+		if pos < 0 {
+			continue
+		}
+
+		key := strings.Trim(strings.TrimSpace(line[:pos]), `"'`)
+		path := joinPath(current, key)
+		if _, found := lines[path]; !found {
+			lines[path] = number
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// suggest format suggestion of the closest candidate
+func suggest(key string, candidates []string) string {
+	best := ""
+	bestDistance := len(key)/2 + 1
+	for _, candidate := range candidates {
+		distance := levenshtein(key, candidate)
+		if distance < bestDistance {
+			best = candidate
+			bestDistance = distance
+		}
+	}
+
+	if best == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(", did you mean [%s]?", best)
+}
+
+// levenshtein edit distance between two strings
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+
+	return previous[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+
+	if c < a {
+		a = c
+	}
+
+	return a
+}
+
+// toTables get tables of a table or an array of tables value
+func toTables(value interface{}) ([]map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{v}, true
+	case []map[string]interface{}:
+		return v, true
+	default:
+		return nil, false
+	}
+}
+
+func optionNames(schema actionSchema) []string {
+	names := make([]string, 0, len(schema.Options))
+	for name := range schema.Options {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+func describeType(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case int64:
+		return "integer"
+	case float64:
+		return "float"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "table"
+	case []map[string]interface{}:
+		return "array of tables"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
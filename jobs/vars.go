@@ -0,0 +1,105 @@
+package jobs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ImportEnv import allowed environment variables by their names
+func (c *Context) ImportEnv(names ...string) {
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		value, found := os.LookupEnv(name)
+		if found {
+			c.Set(name, value)
+		}
+	}
+}
+
+// LoadVars set values from a json object file or a KEY=VALUE .env file
+func (c *Context) LoadVars(filePath string) error {
+	buffer, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]interface{}
+	if strings.ToLower(filepath.Ext(filePath)) == ".json" {
+		values, err = parseJSONVars(buffer)
+	} else {
+		values, err = parseEnvVars(buffer)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	for key, value := range values {
+		c.SetValue(key, value)
+	}
+
+	return nil
+}
+
+// parseJSONVars parse json object, numbers keep integer type when possible
+func parseJSONVars(buffer []byte) (map[string]interface{}, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(buffer))
+	decoder.UseNumber()
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	values, ok := normalizeConfig(value).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("vars file must contain a json object")
+	}
+
+	return values, nil
+}
+
+// parseEnvVars parse KEY=VALUE lines, blank lines, # comments and the export prefix are skipped,
+// quoted values are unquoted
+func parseEnvVars(buffer []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	scanner := bufio.NewScanner(bytes.NewReader(buffer))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+		pos := strings.Index(line, "=")
+		if pos <= 0 {
+			return nil, fmt.Errorf("line %d: expect KEY=VALUE", number)
+		}
+
+		key := strings.TrimSpace(line[:pos])
+		value := strings.TrimSpace(line[pos+1:])
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", number, err)
+			}
+			value = unquoted
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+
+		values[key] = value
+	}
+
+	return values, scanner.Err()
+}
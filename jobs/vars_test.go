@@ -0,0 +1,27 @@
+package jobs
+
+import (
+	"os"
+	"testing"
+)
+
+func TestImportEnv(t *testing.T) {
+	os.Setenv("CRAWL_TEST_HOME", "/home/crawl")
+	os.Setenv("CRAWL_TEST_USER", "crawl")
+	defer os.Unsetenv("CRAWL_TEST_HOME")
+	defer os.Unsetenv("CRAWL_TEST_USER")
+
+	ctx := NewContext()
+	ctx.ImportEnv("CRAWL_TEST_HOME", " CRAWL_TEST_USER", "", " ")
+
+	for key, expect := range map[string]string{"CRAWL_TEST_HOME": "/home/crawl", "CRAWL_TEST_USER": "crawl"} {
+		value, err := ctx.String(key)
+		if err != nil || value != expect {
+			t.Errorf("%s = %s, %v, expect %s", key, value, err, expect)
+		}
+	}
+
+	if values := ctx.Values(); len(values) != 2 {
+		t.Errorf("got %d values, expect 2: %v", len(values), values)
+	}
+}
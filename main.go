@@ -1,55 +1,456 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/nzai/crawl/jobs"
 	"go.uber.org/zap"
 )
 
+// exit codes
+const (
+	exitOK = 0
+	// exitFailure job failed at runtime
+	exitFailure = 1
+	// exitConfig invalid arguments, job files or variables, nothing ran
+	exitConfig = 2
+)
+
+const usage = `usage:
+	crawl [run] [flags] job.toml ...      run job files
+	crawl plan [flags] job.toml ...       print what job files would do without side effects
+	crawl serve [flags] job.toml ...      run job files on their schedules
+	crawl validate job.toml ...           check job files against action schemas
+	crawl list-actions                    list actions and their options
+	crawl convert source.toml target.yaml convert job file between toml, yaml and json
+
+run "crawl <command> -h" for the flags of a command
+
+exit codes:
+	0 success
+	1 job failed at runtime
+	2 invalid arguments, job files or variables
+`
+
 func main() {
-	c := zap.NewDevelopmentConfig()
-	c.DisableStacktrace = true
+	os.Exit(command(os.Args[1:]))
+}
+
+// command dispatch subcommand, run by default
+func command(args []string) int {
+	name := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "run", "plan", "serve", "validate", "list-actions", "convert":
+			name = args[0]
+			args = args[1:]
+		case "help":
+			fmt.Print(usage)
+			return exitOK
+		}
+	}
+
+	fs := flag.NewFlagSet("crawl "+name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage, "\nflags of ", name, ":\n")
+		fs.PrintDefaults()
+	}
+
+	switch name {
+	case "plan":
+		return run(fs, args, true)
+	case "serve":
+		return serve(fs, args)
+	case "validate":
+		return validate(fs, args)
+	case "list-actions":
+		return listActions(fs, args)
+	case "convert":
+		return convert(fs, args)
+	default:
+		return run(fs, args, false)
+	}
+}
+
+// run run or plan job files
+func run(fs *flag.FlagSet, args []string, planMode bool) int {
+	command := "run"
+	if planMode {
+		command = "plan"
+	}
+
+	o := addRunFlags(fs, command)
+	err := fs.Parse(args)
+	if err != nil {
+		return exitConfig
+	}
 
-	logger, _ := c.Build()
-	defer logger.Sync()
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return exitConfig
+	}
+
+	var view *progressView
+	switch o.progress {
+	case "auto", "":
+		if !planMode && !o.dryRun && isTerminal(os.Stderr) {
+			view = newProgressView(os.Stderr)
+		}
+	case "always":
+		if !planMode && !o.dryRun {
+			view = newProgressView(os.Stderr)
+		}
+	case "never":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid progress [%s], expect auto, always or never\n", o.progress)
+		return exitConfig
+	}
+
+	// logs to stderr print above the progress view
+	var logOutput io.Writer
+	if view != nil {
+		logOutput = view
+	}
 
-	undo := zap.ReplaceGlobals(logger)
+	undo, err := o.log.setup(logOutput)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
 	defer undo()
 
-	if len(os.Args) < 2 {
-		fmt.Println("usage:\n\tcrawl your_job_config.toml")
-		os.Exit(1)
+	levels, err := parseLevels(o.levelParallel)
+	if err != nil {
+		zap.L().Error("parse level parallel failed", zap.Error(err), zap.String("value", o.levelParallel))
+		return exitConfig
+	}
+
+	err = o.setProxies()
+	if err != nil {
+		zap.L().Error("parse proxy failed", zap.Error(err), zap.String("value", o.proxy))
+		return exitConfig
 	}
 
 	rootPath, err := os.Getwd()
 	if err != nil {
-		zap.L().Fatal("get current dir failed", zap.Error(err))
+		zap.L().Error("get current dir failed", zap.Error(err))
+		return exitFailure
+	}
+
+	stopMetrics := serveMetrics(o.metrics)
+	defer stopMetrics()
+
+	tracer, err := o.newTracer()
+	if err != nil {
+		zap.L().Error("create tracer failed", zap.Error(err))
+		return exitConfig
 	}
+	defer tracer.Close()
+	defer jobs.CloseBrowsers()
 
-	jobPath := os.Args[1]
+	// an interrupt cancels the jobs, browsers are closed once they stopped
+	done, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer cancelOnSignal(cancel)()
 
 	zap.L().Info("arguments parse success",
-		zap.String("jobPath", jobPath),
+		zap.Strings("jobPaths", fs.Args()),
 		zap.String("rootPath", rootPath))
 
 	start := time.Now()
 
-	_jobs, err := jobs.ReadFile(jobPath)
-	if err != nil {
-		zap.L().Fatal("read job file failed", zap.Error(err), zap.String("path", jobPath))
+	// read every job file first, so that a config error fails before anything runs
+	jobFiles := make([][]*jobs.Job, fs.NArg())
+	ctxs := make([]*jobs.Context, fs.NArg())
+	for index, path := range fs.Args() {
+		jobFiles[index], err = jobs.ReadFile(path)
+		if err != nil {
+			zap.L().Error("read job file failed", zap.Error(err), zap.String("path", path))
+			return exitConfig
+		}
+
+		ctxs[index], err = o.newContext(rootPath)
+		if err != nil {
+			return exitConfig
+		}
+	}
+
+	scheduler := jobs.NewScheduler(o.parallel, levels)
+	if planMode || o.dryRun {
+		plan := jobs.NewPlan(os.Stdout, o.fetch)
+		for _, ctx := range ctxs {
+			ctx.SetPlan(plan)
+		}
+	}
+
+	failed := make([]bool, len(jobFiles))
+	reports := make([]fileReport, len(jobFiles))
+	for index := range reports {
+		reports[index] = fileReport{Path: fs.Arg(index), Status: reportSkipped}
+	}
+
+	execute := func(index int) {
+		ctx := ctxs[index]
+		ctx.SetScheduler(scheduler)
+		ctx.SetCancel(done)
+
+		progress := jobs.NewProgress(jobFiles[index])
+		ctx.SetProgress(progress)
+
+		viewIndex := -1
+		if view != nil {
+			viewIndex = view.add(fs.Arg(index), progress)
+		}
+
+		var err error
+		endTrace := ctx.StartTrace(tracer, fs.Arg(index))
+		defer func() {
+			endTrace(err)
+
+			reports[index].Status = reportSuccess
+			if err != nil {
+				reports[index].Status = reportFailed
+				reports[index].Error = err.Error()
+			}
+			reports[index].Report = progress.Report()
+
+			if viewIndex >= 0 {
+				view.end(viewIndex, reports[index].Status)
+			}
+		}()
+
+		for _, job := range jobFiles[index] {
+			err = job.Execute(ctx)
+			if err != nil {
+				zap.L().Error("do job failed", zap.Error(err), zap.String("path", fs.Arg(index)))
+				failed[index] = true
+				return
+			}
+		}
+	}
+
+	if view != nil {
+		view.start()
+	}
+
+	// plan output keeps the order of job files
+	if o.concurrent && !planMode && !o.dryRun {
+		wg := new(sync.WaitGroup)
+		wg.Add(len(jobFiles))
+		for index := range jobFiles {
+			go func(index int) {
+				defer wg.Done()
+				execute(index)
+			}(index)
+		}
+		wg.Wait()
+	} else {
+		for index := range jobFiles {
+			execute(index)
+			if failed[index] {
+				break
+			}
+		}
+	}
+
+	if view != nil {
+		view.finish()
+	}
+
+	status := reportSuccess
+	for _, fail := range failed {
+		if fail {
+			status = reportFailed
+		}
 	}
 
+	if o.report != "" {
+		end := time.Now()
+		err = writeReport(o.report, runReport{
+			Start:    start,
+			End:      end,
+			Duration: end.Sub(start),
+			Status:   status,
+			Files:    reports,
+		})
+		if err != nil {
+			zap.L().Error("write report failed", zap.Error(err), zap.String("path", o.report))
+		}
+	}
+
+	stats := scheduler.Stats()
+	fields := []zap.Field{
+		zap.Duration("in", time.Now().Sub(start)),
+		zap.Uint64("branches", stats.Acquired),
+		zap.Int("maxQueueDepth", stats.MaxWaiting),
+	}
+
+	if status == reportFailed {
+		zap.L().Error("crawl failed", fields...)
+		return exitFailure
+	}
+
+	zap.L().Info("crawl success", fields...)
+
+	return exitOK
+}
+
+// cancelOnSignal cancel on the first SIGINT or SIGTERM, a second one exits at once
+// without waiting for jobs, returns function to stop listening
+func cancelOnSignal(cancel context.CancelFunc) func() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig, ok := <-signals
+		if !ok {
+			return
+		}
+
+		zap.L().Warn("interrupted, canceling jobs, interrupt again to exit at once", zap.String("signal", sig.String()))
+		cancel()
+
+		sig, ok = <-signals
+		if !ok {
+			return
+		}
+
+		zap.L().Error("interrupted again, exit without waiting for jobs", zap.String("signal", sig.String()))
+		zap.L().Sync()
+		os.Exit(exitFailure)
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
+// newContext create root context seeded with, from lowest to highest precedence,
+// environment, vars file and command line values
+func (o runOptions) newContext(rootPath string) (*jobs.Context, error) {
 	ctx := jobs.NewContextFromEnv(rootPath)
+	ctx.SetStrict(o.strict)
+	if o.envNames != "" {
+		ctx.ImportEnv(strings.Split(o.envNames, ",")...)
+	}
+
+	if o.varsPath != "" {
+		err := ctx.LoadVars(o.varsPath)
+		if err != nil {
+			zap.L().Error("load vars file failed", zap.Error(err), zap.String("path", o.varsPath))
+			return nil, err
+		}
+	}
+
+	for key, value := range o.sets {
+		ctx.Set(key, value)
+	}
+
+	return ctx, nil
+}
+
+// validate validate job files and print problems
+func validate(fs *flag.FlagSet, args []string) int {
+	err := fs.Parse(args)
+	if err != nil {
+		return exitConfig
+	}
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return exitConfig
+	}
 
-	for _, job := range _jobs {
-		err = job.Execute(ctx)
+	code := exitOK
+	for _, path := range fs.Args() {
+		problems, err := jobs.ValidateFile(path)
 		if err != nil {
-			zap.L().Fatal("do job failed", zap.Error(err))
+			fmt.Printf("%s: %v\n", path, err)
+			code = exitConfig
+			continue
 		}
+
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+
+		if len(problems) > 0 {
+			code = exitConfig
+			continue
+		}
+
+		fmt.Printf("%s: ok\n", path)
+	}
+
+	return code
+}
+
+// listActions print actions and their options
+func listActions(fs *flag.FlagSet, args []string) int {
+	err := fs.Parse(args)
+	if err != nil {
+		return exitConfig
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, action := range jobs.Actions() {
+		fmt.Fprintf(writer, "%s\n", action.Name)
+		for _, option := range action.Options {
+			required := ""
+			if option.Required {
+				required = "required"
+			}
+
+			fmt.Fprintf(writer, "\t%s\t%s\t%s\n", option.Name, option.Type, required)
+		}
+
+		if action.Else != "" {
+			fmt.Fprintf(writer, "\t[%s]\tsibling table of else jobs\t\n", action.Else)
+		}
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return exitFailure
+	}
+
+	return exitOK
+}
+
+// convert convert job file to another format
+func convert(fs *flag.FlagSet, args []string) int {
+	o := addLogFlags(fs)
+	err := fs.Parse(args)
+	if err != nil {
+		return exitConfig
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return exitConfig
+	}
+
+	undo, err := o.setup(nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	defer undo()
+
+	err = jobs.ConvertFile(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return exitConfig
 	}
 
-	zap.L().Info("crawl success", zap.Duration("in", time.Now().Sub(start)))
+	return exitOK
 }
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nzai/crawl/jobs"
+)
+
+const (
+	// refreshInterval interval of redrawing the progress view
+	refreshInterval = 500 * time.Millisecond
+	// defaultWidth terminal width when COLUMNS is not set
+	defaultWidth = 120
+)
+
+// progressView live progress of job files redrawn in place at the bottom of a terminal
+//
+// logs written through the view are printed above it, so they do not
+// break the redrawn lines
+type progressView struct {
+	out   io.Writer
+	width int
+	files []viewFile
+	lines int
+	stop  chan struct{}
+	done  chan struct{}
+	mutex sync.Mutex
+}
+
+// viewFile progress of one job file
+type viewFile struct {
+	path     string
+	progress *jobs.Progress
+	// status elapsed set when the job file finished
+	status  string
+	elapsed time.Duration
+}
+
+// isTerminal check whether file is a terminal
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// newProgressView create progress view drawing to out
+func newProgressView(out io.Writer) *progressView {
+	width, err := strconv.Atoi(os.Getenv("COLUMNS"))
+	if err != nil || width <= 0 {
+		width = defaultWidth
+	}
+
+	return &progressView{out: out, width: width, stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+// add show progress of job file, returns index of the job file in the view
+func (v *progressView) add(path string, progress *jobs.Progress) int {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.files = append(v.files, viewFile{path: path, progress: progress})
+	return len(v.files) - 1
+}
+
+// end mark job file finished, its elapsed time stops
+func (v *progressView) end(index int, status string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.files[index].status = status
+	v.files[index].elapsed = v.files[index].progress.Elapsed()
+}
+
+// start redraw view in background until stopped
+func (v *progressView) start() {
+	go func() {
+		defer close(v.done)
+
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+
+		for {
+			v.mutex.Lock()
+			v.clear()
+			v.draw()
+			v.mutex.Unlock()
+
+			select {
+			case <-ticker.C:
+			case <-v.stop:
+				return
+			}
+		}
+	}()
+}
+
+// finish draw final progress and stop redrawing, the last view stays on screen
+func (v *progressView) finish() {
+	close(v.stop)
+	<-v.done
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.clear()
+	v.draw()
+
+	// later logs go below the final view
+	v.lines = 0
+	v.files = nil
+}
+
+// Write print log lines above the view
+func (v *progressView) Write(p []byte) (int, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.clear()
+	n, err := v.out.Write(p)
+	v.draw()
+
+	return n, err
+}
+
+// Sync nothing buffered
+func (v *progressView) Sync() error {
+	return nil
+}
+
+// clear erase drawn lines
+func (v *progressView) clear() {
+	if v.lines > 0 {
+		fmt.Fprintf(v.out, "\x1b[%dA\x1b[J", v.lines)
+		v.lines = 0
+	}
+}
+
+// draw print one header line per job file and one line per job node
+func (v *progressView) draw() {
+	builder := new(strings.Builder)
+	for _, file := range v.files {
+		header := fmt.Sprintf("%s  %s", file.path, file.progress.Elapsed().Round(time.Second))
+		if file.status != "" {
+			header = fmt.Sprintf("%s  %s in %s", file.path, file.status, file.elapsed.Round(time.Millisecond))
+		}
+		v.line(builder, header)
+
+		for _, node := range file.progress.Nodes() {
+			running := node.Started - node.Completed - node.Failed
+			text := fmt.Sprintf("%s%-*s %6d ok %4d failed %4d running",
+				strings.Repeat("  ", node.Depth+1), 28-node.Depth*2, node.Path, node.Completed, node.Failed, running)
+
+			// fan-out nodes show how many of the contexts they produced are finished
+			if node.Produced > 0 {
+				text += fmt.Sprintf("  %d/%d (%d%%)", node.Done, node.Produced, node.Done*100/node.Produced)
+			}
+
+			v.line(builder, text)
+		}
+	}
+
+	io.WriteString(v.out, builder.String())
+}
+
+// line write line truncated to terminal width, so that it never wraps
+func (v *progressView) line(builder *strings.Builder, text string) {
+	if len(text) >= v.width {
+		text = text[:v.width-1]
+	}
+
+	builder.WriteString("\x1b[2K")
+	builder.WriteString(text)
+	builder.WriteString("\n")
+	v.lines++
+}
+
+// runReport end-of-run report of every job file
+type runReport struct {
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"duration"`
+	Status   string        `json:"status"`
+	Files    []fileReport  `json:"files"`
+}
+
+// fileReport report of one job file
+type fileReport struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	jobs.Report
+}
+
+// run and job file statuses of report
+const (
+	reportSuccess = "success"
+	reportFailed  = "failed"
+	// reportSkipped job file not run because an earlier one failed
+	reportSkipped = "skipped"
+)
+
+// writeReport write report as indented json
+func writeReport(path string, report runReport) error {
+	buffer, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(buffer, '\n'), 0644)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/nzai/crawl/daemon"
+	"github.com/nzai/crawl/jobs"
+	"go.uber.org/zap"
+)
+
+// apiTokenEnv environment variable of the http control api token
+const apiTokenEnv = "CRAWL_API_TOKEN"
+
+// serve run job files on their schedules until interrupted
+func serve(fs *flag.FlagSet, args []string) int {
+	o := addRunFlags(fs, "serve")
+	reloadInterval := fs.Duration("reload", 5*time.Second, "interval of checking job files for changes")
+	historySize := fs.Int("history-size", 100, "number of recent runs kept")
+	historyFile := fs.String("history-file", "", "append finished runs to json lines file and load recent runs from it")
+	listen := fs.String("listen", "", "serve the http control api on address, e.g. :8080 for 127.0.0.1:8080, 0.0.0.0:8080 for every interface")
+	token := fs.String("token", "", "bearer token the http control api requires, defaults to $"+apiTokenEnv)
+	err := fs.Parse(args)
+	if err != nil {
+		return exitConfig
+	}
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return exitConfig
+	}
+
+	undo, err := o.log.setup(nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	defer undo()
+
+	// the token must not reach run contexts, which import CRAWL_ variables
+	if *token == "" {
+		*token = os.Getenv(apiTokenEnv)
+	}
+	os.Unsetenv(apiTokenEnv)
+
+	if *listen != "" && *token == "" {
+		zap.L().Error("http api requires a token, set -token or $" + apiTokenEnv)
+		return exitConfig
+	}
+
+	levels, err := parseLevels(o.levelParallel)
+	if err != nil {
+		zap.L().Error("parse level parallel failed", zap.Error(err), zap.String("value", o.levelParallel))
+		return exitConfig
+	}
+
+	err = o.setProxies()
+	if err != nil {
+		zap.L().Error("parse proxy failed", zap.Error(err), zap.String("value", o.proxy))
+		return exitConfig
+	}
+
+	rootPath, err := os.Getwd()
+	if err != nil {
+		zap.L().Error("get current dir failed", zap.Error(err))
+		return exitFailure
+	}
+
+	stopMetrics := serveMetrics(o.metrics)
+	defer stopMetrics()
+
+	tracer, err := o.newTracer()
+	if err != nil {
+		zap.L().Error("create tracer failed", zap.Error(err))
+		return exitConfig
+	}
+	defer tracer.Close()
+	defer jobs.CloseBrowsers()
+
+	history, err := daemon.NewHistory(*historySize, *historyFile)
+	if err != nil {
+		zap.L().Error("load run history failed", zap.Error(err), zap.String("path", *historyFile))
+		return exitConfig
+	}
+
+	// every run of every job file shares the run-wide parallelism
+	scheduler := jobs.NewScheduler(o.parallel, levels)
+	d, err := daemon.New(daemon.Options{
+		Paths:          fs.Args(),
+		ReloadInterval: *reloadInterval,
+		Tracer:         tracer,
+		Token:          *token,
+		NewContext: func() (*jobs.Context, error) {
+			ctx, err := o.newContext(rootPath)
+			if err != nil {
+				return nil, err
+			}
+
+			ctx.SetScheduler(scheduler)
+			return ctx, nil
+		},
+	}, history)
+	if err != nil {
+		zap.L().Error("load job files failed", zap.Error(err))
+		return exitConfig
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	defer cancelOnSignal(cancel)()
+
+	if *listen != "" {
+		address := listenAddress(*listen)
+		server := &http.Server{Addr: address, Handler: d.Handler()}
+		go func() {
+			err := server.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				zap.L().Error("serve http api failed", zap.Error(err), zap.String("address", address))
+				cancel()
+			}
+		}()
+		defer server.Close()
+
+		zap.L().Info("http api listening", zap.String("address", address))
+	}
+
+	err = d.Run(ctx)
+	if err != nil {
+		zap.L().Error("serve failed", zap.Error(err))
+		return exitFailure
+	}
+
+	return exitOK
+}
+
+// listenAddress bind addresses without host to the loopback interface only
+func listenAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host != "" {
+		return address
+	}
+
+	return net.JoinHostPort("127.0.0.1", port)
+}
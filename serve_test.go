@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestListenAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		expect  string
+	}{
+		// without host only the loopback interface
+		{":8080", "127.0.0.1:8080"},
+		{"0.0.0.0:8080", "0.0.0.0:8080"},
+		{"localhost:8080", "localhost:8080"},
+		{"[::1]:8080", "[::1]:8080"},
+	}
+
+	for _, test := range tests {
+		if got := listenAddress(test.address); got != test.expect {
+			t.Errorf("listen %s: got %s, expect %s", test.address, got, test.expect)
+		}
+	}
+}
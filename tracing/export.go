@@ -0,0 +1,215 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FileExporter write spans to a json lines file
+type FileExporter struct {
+	file   *os.File
+	writer *bufio.Writer
+	mutex  sync.Mutex
+}
+
+// NewFileExporter create file exporter, the file is truncated
+func NewFileExporter(path string) (*FileExporter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileExporter{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+// Export write spans, one json object per line
+func (e *FileExporter) Export(spans []SpanData) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	encoder := json.NewEncoder(e.writer)
+	for _, span := range spans {
+		err := encoder.Encode(span)
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.writer.Flush()
+}
+
+// Close close file
+func (e *FileExporter) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	err := e.writer.Flush()
+	if err != nil {
+		e.file.Close()
+		return err
+	}
+
+	return e.file.Close()
+}
+
+// OTLPExporter send spans to an OpenTelemetry collector with OTLP/HTTP in json encoding
+type OTLPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+}
+
+// NewOTLPExporter create otlp exporter, endpoint without path defaults to /v1/traces,
+// e.g. http://localhost:4318
+func NewOTLPExporter(endpoint, service string) (*OTLPExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid otlp endpoint [%s], expect http or https url", endpoint)
+	}
+
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+
+	return &OTLPExporter{
+		endpoint: u.String(),
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// otlp json messages, field names follow the protobuf json mapping
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// otlp span kind and status codes
+const (
+	otlpKindInternal = 1
+	otlpStatusOk     = 1
+	otlpStatusError  = 2
+)
+
+// Export post spans to collector
+func (e *OTLPExporter) Export(spans []SpanData) error {
+	otlpSpans := make([]otlpSpan, len(spans))
+	for index, span := range spans {
+		otlpSpans[index] = otlpSpan{
+			TraceID:           span.TraceID,
+			SpanID:            span.SpanID,
+			ParentSpanID:      span.ParentID,
+			Name:              span.Name,
+			Kind:              otlpKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+			Status:            otlpStatus{Code: otlpStatusOk},
+		}
+
+		if span.Error != "" {
+			otlpSpans[index].Status = otlpStatus{Code: otlpStatusError, Message: span.Error}
+		}
+	}
+
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes(map[string]string{"service.name": e.service})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: e.service}, Spans: otlpSpans}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	response, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("otlp collector response status code: %d, %s", response.StatusCode, message)
+	}
+
+	return nil
+}
+
+// Close nothing to release
+func (e *OTLPExporter) Close() error {
+	return nil
+}
+
+// otlpAttributes convert attributes sorted by key
+func otlpAttributes(attributes map[string]string) []otlpAttribute {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	list := make([]otlpAttribute, len(keys))
+	for index, key := range keys {
+		list[index] = otlpAttribute{Key: key, Value: otlpValue{StringValue: attributes[key]}}
+	}
+
+	return list
+}
@@ -0,0 +1,132 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// TraceID trace identifier
+type TraceID [16]byte
+
+// String hex encoded id
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID span identifier
+type SpanID [8]byte
+
+// String hex encoded id, empty for the zero id
+func (id SpanID) String() string {
+	if id == (SpanID{}) {
+		return ""
+	}
+
+	return hex.EncodeToString(id[:])
+}
+
+// Span timed operation of a trace, safe for concurrent use
+type Span struct {
+	tracer *Tracer
+	data   SpanData
+	ended  bool
+	mutex  sync.Mutex
+}
+
+// SpanData finished span
+type SpanData struct {
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Name       string            `json:"name"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Start start child span, a nil parent starts a new trace
+//
+// a nil tracer returns a nil span, every span method accepts a nil span,
+// so callers do not check whether tracing is enabled
+func (t *Tracer) Start(parent *Span, name string) *Span {
+	if t == nil {
+		return nil
+	}
+
+	span := &Span{tracer: t, data: SpanData{Name: name, Start: time.Now()}}
+
+	var spanID SpanID
+	rand.Read(spanID[:])
+	span.data.SpanID = spanID.String()
+
+	if parent == nil {
+		var traceID TraceID
+		rand.Read(traceID[:])
+		span.data.TraceID = traceID.String()
+		return span
+	}
+
+	span.data.TraceID = parent.data.TraceID
+	span.data.ParentID = parent.data.SpanID
+
+	return span
+}
+
+// Start start child span of span
+func (s *Span) Start(name string) *Span {
+	if s == nil {
+		return nil
+	}
+
+	return s.tracer.Start(s, name)
+}
+
+// SetAttribute set span attribute
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]string)
+	}
+	s.data.Attributes[key] = value
+}
+
+// End end span with the error of the operation, later calls are ignored
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+
+	s.ended = true
+	s.data.End = time.Now()
+	if err != nil {
+		s.data.Error = err.Error()
+	}
+	data := s.data
+	s.mutex.Unlock()
+
+	s.tracer.export(data)
+}
+
+// TraceID get hex encoded trace id
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+
+	return s.data.TraceID
+}
@@ -0,0 +1,146 @@
+package tracing
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// batchSize spans exported at once
+	batchSize = 512
+	// flushInterval max time a finished span waits for export
+	flushInterval = 5 * time.Second
+)
+
+// Exporter export finished spans
+type Exporter interface {
+	Export(spans []SpanData) error
+	Close() error
+}
+
+// Tracer create spans and export them in batches in background
+//
+// spans ended while the queue is full, e.g. when a collector is slow or down,
+// or after close are dropped, tracing never holds up the jobs it traces
+type Tracer struct {
+	exporters []Exporter
+	spans     chan SpanData
+	done      chan struct{}
+	closed    bool
+	dropped   uint64
+	mutex     sync.Mutex
+	closeOnce sync.Once
+}
+
+// NewTracer create tracer exporting to every exporter
+func NewTracer(exporters ...Exporter) *Tracer {
+	t := &Tracer{
+		exporters: exporters,
+		spans:     make(chan SpanData, batchSize*4),
+		done:      make(chan struct{}),
+	}
+	go t.loop()
+
+	return t
+}
+
+// export queue finished span, dropping it when the queue is full or closed
+func (t *Tracer) export(span SpanData) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.closed {
+		t.dropped++
+		return
+	}
+
+	select {
+	case t.spans <- span:
+	default:
+		t.dropped++
+	}
+}
+
+// loop export queued spans when a batch is full, on interval and on close
+func (t *Tracer) loop() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, batchSize)
+	for {
+		select {
+		case span, ok := <-t.spans:
+			if !ok {
+				t.flush(batch)
+				return
+			}
+
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				t.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			t.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (t *Tracer) flush(batch []SpanData) {
+	if len(batch) == 0 {
+		return
+	}
+
+	for _, exporter := range t.exporters {
+		err := exporter.Export(batch)
+		if err != nil {
+			zap.L().Warn("export spans failed", zap.Error(err), zap.Int("spans", len(batch)))
+		}
+	}
+}
+
+// Close export remaining spans and close exporters, spans ending after close are dropped
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+
+	var err error
+	t.closeOnce.Do(func() {
+		t.mutex.Lock()
+		t.closed = true
+		close(t.spans)
+		t.mutex.Unlock()
+		<-t.done
+
+		if dropped := t.Dropped(); dropped > 0 {
+			zap.L().Warn("spans dropped, exporting fell behind", zap.Uint64("spans", dropped))
+		}
+
+		for _, exporter := range t.exporters {
+			e := exporter.Close()
+			if e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+
+	return err
+}
+
+// Dropped get number of spans dropped so far
+func (t *Tracer) Dropped() uint64 {
+	if t == nil {
+		return 0
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.dropped
+}
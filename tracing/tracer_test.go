@@ -0,0 +1,60 @@
+package tracing
+
+import (
+	"testing"
+	"time"
+)
+
+// blockingExporter exporter blocking every export until released
+type blockingExporter struct {
+	release chan struct{}
+}
+
+// Export wait until released
+func (e blockingExporter) Export(spans []SpanData) error {
+	<-e.release
+	return nil
+}
+
+// Close nothing to close
+func (e blockingExporter) Close() error {
+	return nil
+}
+
+func TestTracerDropWhenFull(t *testing.T) {
+	exporter := blockingExporter{release: make(chan struct{})}
+	tracer := NewTracer(exporter)
+
+	// the first batch blocks in the exporter, then the queue fills up
+	total := batchSize*6 + 100
+	ended := make(chan struct{})
+	go func() {
+		defer close(ended)
+		for index := 0; index < total; index++ {
+			tracer.Start(nil, "span").End(nil)
+		}
+	}()
+
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ending spans blocked on a stuck exporter")
+	}
+
+	if dropped := tracer.Dropped(); dropped == 0 || dropped >= uint64(total) {
+		t.Errorf("got %d dropped spans, expect some of %d", dropped, total)
+	}
+
+	close(exporter.release)
+	err := tracer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a span ending after close is dropped instead of panicking
+	before := tracer.Dropped()
+	tracer.Start(nil, "late").End(nil)
+	if dropped := tracer.Dropped(); dropped != before+1 {
+		t.Errorf("late span: got %d dropped spans, expect %d", dropped, before+1)
+	}
+}